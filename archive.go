@@ -0,0 +1,346 @@
+package kaleidoscope
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	ArchiveVersion  = 2
+	ArchiveManifest = "manifest.json"
+	ArchiveBlocks   = "blocks"
+)
+
+type Manifest struct {
+	Version  int
+	Database string
+	Root     string
+	KeyType  string
+	Entries  []ManifestEntry
+}
+
+type ManifestEntry struct {
+	Collection string `json:",omitempty"`
+	Key        string
+	Hash       string
+}
+
+type Record struct {
+	Collection string `json:"collection,omitempty"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	Timestamp  string `json:"timestamp"`
+	Expires    int64  `json:"expires,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+	Writer     string `json:"writer,omitempty"`
+}
+
+func (d *DB) Export(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := d.entries(d.latest())
+	if err != nil {
+		return err
+	}
+	manifest := Manifest{
+		Version:  ArchiveVersion,
+		Database: d.name,
+		Root:     d.latest(),
+		KeyType:  "rsa",
+		Entries:  entries,
+	}
+
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeTarFile(tw, ArchiveManifest, data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range manifest.Entries {
+		enc, err := d.client.Cat(entry.Hash+"/value", RequestOptions{})
+		if err != nil {
+			return err
+		}
+		err = writeTarFile(tw, path.Join(ArchiveBlocks, entry.Hash), enc)
+		if err != nil {
+			return err
+		}
+		err = d.exportChunks(tw, entry.Hash, enc)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// entries lists the keys of root followed by the keys of its collections.
+func (d *DB) entries(root string) ([]ManifestEntry, error) {
+	links, err := d.links(root)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	for _, link := range links {
+		if reserved(link.Name) {
+			continue
+		}
+		entries = append(entries, ManifestEntry{Key: unescapeKey(link.Name), Hash: link.Hash})
+	}
+
+	hash, ok, err := d.walk(root, collectionRoot)
+	if err != nil || !ok {
+		return entries, err
+	}
+	collections, err := d.links(hash)
+	if err != nil {
+		return nil, err
+	}
+	for _, collection := range collections {
+		links, err := d.links(collection.Hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			entries = append(entries, ManifestEntry{
+				Collection: unescapeKey(collection.Name),
+				Key:        unescapeKey(link.Name),
+				Hash:       link.Hash,
+			})
+		}
+	}
+	return entries, nil
+}
+
+func (d *DB) exportChunks(tw *tar.Writer, hash string, enc []byte) error {
+	plain, err := d.keystore.Decrypt(enc)
+	if err != nil {
+		return err
+	}
+	m, value, err := unwrap(plain)
+	if err != nil || m.layout != chunkedLayout {
+		return err
+	}
+	header, err := parseChunkHeader(value)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < header.chunks; i++ {
+		name := strconv.Itoa(i)
+		chunk, err := d.client.Cat(hash+"/"+name, RequestOptions{})
+		if err != nil {
+			return err
+		}
+		err = writeTarFile(tw, path.Join(ArchiveBlocks, hash, name), chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return d.Each(func(r Record) error {
+		return enc.Encode(r)
+	})
+}
+
+func (d *DB) Each(fn func(Record) error) error {
+	// Values are read and passed to fn without holding d.mu, so that fn can
+	// use the database. Entries are immutable once listed, like in Find.
+	d.mu.Lock()
+	entries, err := d.entries(d.latest())
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		plain, err := d.plain(entry.Hash)
+		if err != nil {
+			return err
+		}
+		m, value, err := unwrap(plain)
+		if err != nil {
+			return err
+		}
+		d.remember(entry.Hash, m.expires)
+		if expired(m.expires) {
+			continue
+		}
+		if m.layout == chunkedLayout {
+			value, err = d.assemble(entry.Hash, value)
+			if err != nil {
+				return err
+			}
+		}
+		err = fn(Record{Collection: entry.Collection, Key: entry.Key, Value: string(value), Timestamp: string(m.timestamp), Expires: m.expires, Codec: m.codec, Writer: m.writer})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) Import(r io.Reader) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var manifest Manifest
+	blocks := map[string][]byte{}
+	chunks := map[string][][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return "", err
+		}
+		if hdr.Name == ArchiveManifest {
+			err = json.Unmarshal(data, &manifest)
+			if err != nil {
+				return "", err
+			}
+		} else if dir, name := path.Split(hdr.Name); path.Clean(dir) == ArchiveBlocks {
+			blocks[name] = data
+		} else if parent, hash := path.Split(path.Clean(dir)); path.Clean(parent) == ArchiveBlocks {
+			i, err := strconv.Atoi(name)
+			if err != nil {
+				return "", fmt.Errorf("Unexpected chunk in archive: %s", hdr.Name)
+			}
+			for len(chunks[hash]) <= i {
+				chunks[hash] = append(chunks[hash], nil)
+			}
+			chunks[hash][i] = data
+		}
+	}
+	// Version 1 archives are the same without collections.
+	if manifest.Version < 1 || manifest.Version > ArchiveVersion {
+		return "", fmt.Errorf("Unsupported archive version: %d", manifest.Version)
+	}
+	for _, entry := range manifest.Entries {
+		err := checkKey(entry.Key)
+		if err == nil && entry.Collection != "" {
+			err = checkKey(entry.Collection)
+		}
+		if err != nil {
+			return "", err
+		}
+		enc, ok := blocks[entry.Hash]
+		if !ok {
+			return "", fmt.Errorf("Archive has no block for key: %s", entry.Key)
+		}
+		_, err = d.keystore.Decrypt(enc)
+		if err != nil {
+			return "", fmt.Errorf("Archive of %s was not encrypted with the key of %s: %s",
+				manifest.Database, d.name, err)
+		}
+	}
+
+	root := EmptyDirMultiHash
+	collections := map[string]string{}
+	for _, entry := range manifest.Entries {
+		hash, err := d.importValue(blocks[entry.Hash], chunks[entry.Hash])
+		if err != nil {
+			return "", err
+		}
+		d.state.Objects = append(d.state.Objects, hash)
+		if entry.Collection == "" {
+			root, err = d.client.ObjectPatchAddLink(root, escapeKey(entry.Key), hash, RequestOptions{})
+		} else {
+			dir, ok := collections[entry.Collection]
+			if !ok {
+				dir = EmptyDirMultiHash
+			}
+			collections[entry.Collection], err = d.client.ObjectPatchAddLink(dir, escapeKey(entry.Key), hash, RequestOptions{})
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	root, err := d.importCollections(root, collections)
+	if err != nil {
+		return "", err
+	}
+	_, err = d.apply(Operation{Type: "reset", Hash: root}, false)
+	if err != nil {
+		return "", err
+	}
+	_, err = d.rebuildAll()
+	if err != nil {
+		return "", err
+	}
+	return d.latest(), nil
+}
+
+// importCollections links the imported collection directories into root.
+func (d *DB) importCollections(root string, collections map[string]string) (string, error) {
+	if len(collections) == 0 {
+		return root, nil
+	}
+	names := make([]string, 0, len(collections))
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dir := EmptyDirMultiHash
+	for _, name := range names {
+		var err error
+		dir, err = d.client.ObjectPatchAddLink(dir, escapeKey(name), collections[name], RequestOptions{})
+		if err != nil {
+			return "", err
+		}
+	}
+	return d.client.ObjectPatchAddLink(root, collectionRoot, dir, RequestOptions{})
+}
+
+func (d *DB) importValue(enc []byte, chunks [][]byte) (string, error) {
+	opts := RequestOptions{"wrap-with-directory": "true"}
+	if len(chunks) == 0 {
+		return d.client.Add("value", bytes.NewReader(enc), opts)
+	}
+	i := 0
+	return d.client.AddFiles(func() (string, io.Reader, error) {
+		defer func() { i++ }()
+		switch {
+		case i < len(chunks) && chunks[i] == nil:
+			return "", nil, fmt.Errorf("Archive is missing chunk %d", i)
+		case i < len(chunks):
+			return strconv.Itoa(i), bytes.NewReader(chunks[i]), nil
+		case i == len(chunks):
+			return "value", bytes.NewReader(enc), nil
+		}
+		return "", nil, io.EOF
+	}, opts)
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
@@ -0,0 +1,190 @@
+package kaleidoscope
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKaleidoScopeExportAndImport(t *testing.T) {
+	keystore := testKeystore()
+	enc, _ := keystore.EncryptString(wrapWithMetadata("Some value"))
+
+	var added [][]byte
+	var linked []string
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/object/links":
+			fmt.Fprintln(w, `{"Hash":"QmRoot","Links":[{"Name":"some_key","Hash":"QmValue"}]}`)
+		case "/api/v0/cat":
+			w.Write(enc)
+		case "/api/v0/add":
+			f, _, _ := r.FormFile("file")
+			data, _ := ioutil.ReadAll(f)
+			added = append(added, data)
+			fmt.Fprintln(w, `{"Name":"","Hash":"QmValue","Size":"67"}`)
+		case "/api/v0/object/patch/add-link":
+			linked = append(linked, strings.Join(r.URL.Query()["arg"], " "))
+			fmt.Fprintln(w, `{"Hash":"QmImportedRoot"}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = keystore
+
+	var archive bytes.Buffer
+	err := db.Export(&archive)
+	if err != nil {
+		t.Errorf("Export should not return error, but %s", err)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	tr.Next()
+	var manifest Manifest
+	json.NewDecoder(tr).Decode(&manifest)
+	if manifest.Database != "dbname" || manifest.Root != "QmRoot" || len(manifest.Entries) != 1 {
+		t.Errorf("Export should write manifest, but %v", manifest)
+	}
+
+	root, err := db.Import(&archive)
+	if err != nil {
+		t.Errorf("Import should not return error, but %s", err)
+	}
+	if root != "QmImportedRoot" || db.head != root {
+		t.Errorf("Import should set imported root (QmImportedRoot), but %s", db.head)
+	}
+	if len(added) != 1 || !bytes.Equal(added[0], enc) {
+		t.Errorf("Import should add encrypted blocks as is")
+	}
+	expect := EmptyDirMultiHash + " some_key QmValue"
+	if len(linked) != 1 || linked[0] != expect {
+		t.Errorf("Import should link values (%s), but %v", expect, linked)
+	}
+}
+
+func TestKaleidoScopeExportJSON(t *testing.T) {
+	keystore := testKeystore()
+	enc, _ := keystore.EncryptString(wrapWithMetadata("Some value"))
+
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/object/links" {
+			fmt.Fprintln(w, `{"Hash":"QmRoot","Links":[{"Name":"some_key","Hash":"QmValue"}]}`)
+		} else {
+			w.Write(enc)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = keystore
+
+	var out bytes.Buffer
+	err := db.ExportJSON(&out)
+	if err != nil {
+		t.Errorf("ExportJSON should not return error, but %s", err)
+	}
+	var record Record
+	json.NewDecoder(&out).Decode(&record)
+	if record.Key != "some_key" || record.Value != "Some value" {
+		t.Errorf("ExportJSON should write decrypted records, but %v", record)
+	}
+}
+
+func TestDBEachCallbackUsesDB(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.Set("alice", "1")
+	db.Set("bob", "2")
+
+	done := make(chan error)
+	go func() {
+		done <- db.Each(func(r Record) error {
+			_, err := db.Set(r.Key+"_copy", r.Value)
+			return err
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Each should not return error, but %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Each should not hold the database lock while calling fn")
+	}
+	_, value, err := db.Get("bob_copy")
+	if err != nil || string(value) != "2" {
+		t.Errorf("fn should be able to write to the database, but %q (%v)", value, err)
+	}
+}
+
+func TestKaleidoScopeExportAndImportCollections(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.Set("alice", "root value")
+	db.Collection("users").Set("alice", "user value")
+	db.Collection("users/admins").Set("bob", "admin value")
+
+	var records []string
+	err := db.Each(func(r Record) error {
+		records = append(records, r.Collection+":"+r.Key+"="+r.Value)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Each should not return error, but %s", err)
+	}
+	expect := ":alice=root value,users:alice=user value,users/admins:bob=admin value"
+	if strings.Join(records, ",") != expect {
+		t.Errorf("Each should include collection records (%s), but %v", expect, records)
+	}
+
+	var archive bytes.Buffer
+	err = db.Export(&archive)
+	if err != nil {
+		t.Errorf("Export should not return error, but %s", err)
+	}
+	imported := testDB(ipfs.URL, EmptyDirMultiHash)
+	imported.keystore = db.keystore
+	_, err = imported.Import(&archive)
+	if err != nil {
+		t.Errorf("Import should not return error, but %s", err)
+	}
+
+	loaded := testDB(ipfs.URL, EmptyDirMultiHash)
+	loaded.keystore = db.keystore
+	var batch []Record
+	db.Each(func(r Record) error {
+		batch = append(batch, r)
+		return nil
+	})
+	_, err = loaded.SetBatch(batch)
+	if err != nil {
+		t.Errorf("SetBatch should not return error, but %s", err)
+	}
+
+	for name, restored := range map[string]*DB{"Import": imported, "SetBatch": loaded} {
+		names, _ := restored.Collections()
+		if strings.Join(names, ",") != "users,users/admins" {
+			t.Errorf("%s should restore the collections, but %v", name, names)
+		}
+		_, value, _ := restored.Get("alice")
+		_, user, _ := restored.Collection("users").Get("alice")
+		_, admin, _ := restored.Collection("users/admins").Get("bob")
+		if string(value) != "root value" || string(user) != "user value" || string(admin) != "admin value" {
+			t.Errorf("%s should restore root and collection values, but %s %s %s", name, value, user, admin)
+		}
+	}
+}
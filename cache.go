@@ -0,0 +1,85 @@
+package kaleidoscope
+
+import (
+	"container/list"
+	"sync"
+)
+
+type Cache struct {
+	maxBytes int
+	used     int
+	ll       *list.List
+	items    map[string]*list.Element
+	hits     uint64
+	misses   uint64
+	mu       sync.Mutex
+}
+
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   int
+}
+
+type cacheEntry struct {
+	key   string
+	value interface{}
+	size  int
+}
+
+func NewCache(maxBytes int) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		c.hits++
+		return e.Value.(*cacheEntry).value, true
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *Cache) Add(key string, value interface{}, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.maxBytes {
+		return
+	}
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*cacheEntry)
+		c.used += size - entry.size
+		entry.value = value
+		entry.size = size
+	} else {
+		c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value, size: size})
+		c.used += size
+	}
+	for c.used > c.maxBytes {
+		e := c.ll.Back()
+		entry := e.Value.(*cacheEntry)
+		c.ll.Remove(e)
+		delete(c.items, entry.key)
+		c.used -= entry.size
+	}
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: c.ll.Len(),
+		Bytes:   c.used,
+	}
+}
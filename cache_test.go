@@ -0,0 +1,37 @@
+package kaleidoscope
+
+import (
+	"testing"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewCache(10)
+	cache.Add("a", []byte("aaaa"), 4)
+	cache.Add("b", []byte("bbbb"), 4)
+	cache.Get("a")
+	cache.Add("c", []byte("cccc"), 4)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Cache should evict least recently used entry (b)")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Errorf("Cache should keep recently used entry (a)")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Cache should count hits (2) and misses (1), but %d and %d", stats.Hits, stats.Misses)
+	}
+	if stats.Entries != 2 || stats.Bytes != 8 {
+		t.Errorf("Cache should hold 2 entries (8 bytes), but %d (%d bytes)", stats.Entries, stats.Bytes)
+	}
+}
+
+func TestCacheIgnoresOversizedEntries(t *testing.T) {
+	cache := NewCache(4)
+	cache.Add("a", []byte("aaaaa"), 5)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Cache should not store entries larger than its size")
+	}
+}
@@ -0,0 +1,274 @@
+package kaleidoscope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultChunkSize = 1 << 20
+	chunkedLayout    = "chunked"
+)
+
+type chunkHeader struct {
+	size      int64
+	chunkSize int64
+	chunks    int
+	secret    []byte
+}
+
+func (h chunkHeader) String() string {
+	return strings.Join([]string{
+		strconv.FormatInt(h.size, 10),
+		strconv.FormatInt(h.chunkSize, 10),
+		strconv.Itoa(h.chunks),
+		base64.StdEncoding.EncodeToString(h.secret),
+	}, ":")
+}
+
+func parseChunkHeader(data []byte) (chunkHeader, error) {
+	fields := strings.Split(string(data), ":")
+	if len(fields) != 4 {
+		return chunkHeader{}, fmt.Errorf("Malformed chunk header")
+	}
+	var h chunkHeader
+	var err error
+	h.size, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return chunkHeader{}, err
+	}
+	h.chunkSize, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return chunkHeader{}, err
+	}
+	h.chunks, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return chunkHeader{}, err
+	}
+	h.secret, err = base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return chunkHeader{}, err
+	}
+	return h, nil
+}
+
+func (h chunkHeader) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(h.secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, index int) []byte {
+	// Every value has its own key, so the chunk index is a unique nonce.
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+func (d *DB) SetReader(key string, r io.Reader) (string, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+	hash, err := d.upload(r, metadata{})
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.Objects = append(d.state.Objects, hash)
+	err = d.purge(key)
+	if err != nil {
+		return "", err
+	}
+	return d.setHash(key, hash, true)
+}
+
+func (d *DB) upload(r io.Reader, m metadata) (string, error) {
+	header := chunkHeader{chunkSize: DefaultChunkSize, secret: make([]byte, 32)}
+	_, err := rand.Read(header.secret)
+	if err != nil {
+		return "", err
+	}
+	aead, err := header.cipher()
+	if err != nil {
+		return "", err
+	}
+
+	if m.writer == "" {
+		m.writer, err = d.writer()
+		if err != nil {
+			return "", err
+		}
+	}
+	buf := make([]byte, header.chunkSize)
+	done := false
+	next := func() (string, io.Reader, error) {
+		if done {
+			return "", nil, io.EOF
+		}
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", nil, err
+		}
+		if n > 0 {
+			name := strconv.Itoa(header.chunks)
+			sealed := aead.Seal(nil, chunkNonce(aead, header.chunks), buf[:n], nil)
+			header.chunks++
+			header.size += int64(n)
+			return name, bytes.NewReader(sealed), nil
+		}
+		done = true
+		m.layout = chunkedLayout
+		enc, err := d.keystore.EncryptString(wrap(header.String(), m))
+		if err != nil {
+			return "", nil, err
+		}
+		return "value", bytes.NewReader(enc), nil
+	}
+	return d.client.AddFiles(next, RequestOptions{"wrap-with-directory": "true"})
+}
+
+func (d *DB) GetReader(key string) (io.ReadCloser, error) {
+	return d.GetRange(key, 0, -1)
+}
+
+func (d *DB) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("Invalid offset: %d", offset)
+	}
+	root := d.Head()
+	hash, plain, err := d.read(root, key)
+	if err != nil {
+		return nil, err
+	}
+	m, value, err := unwrap(plain)
+	if err != nil {
+		return nil, err
+	}
+	if expired(m.expires) {
+		return nil, &NotFoundError{Key: key, Root: root}
+	}
+
+	if m.layout != chunkedLayout {
+		size := int64(len(value))
+		if offset > size {
+			offset = size
+		}
+		end := size
+		if length >= 0 && offset+length < size {
+			end = offset + length
+		}
+		return ioutil.NopCloser(bytes.NewReader(value[offset:end])), nil
+	}
+
+	header, err := parseChunkHeader(value)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := header.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if offset > header.size {
+		offset = header.size
+	}
+	remaining := header.size - offset
+	if length >= 0 && length < remaining {
+		remaining = length
+	}
+	return &chunkReader{
+		d:         d,
+		base:      chunkBase(hash, root, key),
+		header:    header,
+		aead:      aead,
+		index:     int(offset / header.chunkSize),
+		skip:      offset % header.chunkSize,
+		remaining: remaining,
+	}, nil
+}
+
+func (d *DB) assemble(base string, value []byte) ([]byte, error) {
+	header, err := parseChunkHeader(value)
+	if err != nil {
+		return []byte{}, err
+	}
+	aead, err := header.cipher()
+	if err != nil {
+		return []byte{}, err
+	}
+	r := &chunkReader{d: d, base: base, header: header, aead: aead, remaining: header.size}
+	return ioutil.ReadAll(r)
+}
+
+func chunkBase(hash, root, key string) string {
+	if hash != "" {
+		return hash
+	}
+	return root + "/" + escapeKey(key)
+}
+
+type chunkReader struct {
+	d         *DB
+	base      string
+	header    chunkHeader
+	aead      cipher.AEAD
+	index     int
+	skip      int64
+	buf       []byte
+	remaining int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	for len(r.buf) == 0 {
+		if r.index >= r.header.chunks {
+			return 0, io.ErrUnexpectedEOF
+		}
+		chunk, err := r.chunk(r.index)
+		if err != nil {
+			return 0, err
+		}
+		r.index++
+		if r.skip > int64(len(chunk)) {
+			r.skip = int64(len(chunk))
+		}
+		r.buf = chunk[r.skip:]
+		r.skip = 0
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *chunkReader) chunk(index int) ([]byte, error) {
+	enc, err := r.d.client.Cat(r.base+"/"+strconv.Itoa(index), RequestOptions{})
+	if err != nil {
+		return []byte{}, err
+	}
+	return r.aead.Open(nil, chunkNonce(r.aead, index), enc, nil)
+}
+
+func (r *chunkReader) Close() error {
+	r.buf = nil
+	r.remaining = 0
+	return nil
+}
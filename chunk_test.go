@@ -0,0 +1,123 @@
+package kaleidoscope
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+)
+
+func testChunkIPFS() *httptest.Server {
+	var mu sync.Mutex
+	files := map[string][]byte{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v0/id":
+			fmt.Fprintln(w, `{"ID":"QmTestPeer"}`)
+		case "/api/v0/add":
+			mr, _ := r.MultipartReader()
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				data, _ := ioutil.ReadAll(part)
+				files[part.FileName()] = data
+				fmt.Fprintln(w, fmt.Sprintf(`{"Name":"%s","Hash":"QmFile","Size":"%d"}`, part.FileName(), len(data)))
+			}
+			fmt.Fprintln(w, `{"Name":"","Hash":"QmBlob","Size":"0"}`)
+		case "/api/v0/object/patch/add-link":
+			fmt.Fprintln(w, `{"Hash":"QmRootAfter"}`)
+		case "/api/v0/cat":
+			w.Write(files[path.Base(r.URL.Query().Get("arg"))])
+		}
+	}))
+}
+
+func TestDBSetReaderAndGetReader(t *testing.T) {
+	ipfs := testChunkIPFS()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	blob := make([]byte, DefaultChunkSize*2+DefaultChunkSize/2)
+	rand.Read(blob)
+	hash, err := db.SetReader("blob", bytes.NewReader(blob))
+	if err != nil {
+		t.Errorf("SetReader should not return error, but %s", err)
+	}
+	if hash != "QmRootAfter" {
+		t.Errorf("SetReader should return database's hash (QmRootAfter), but %s", hash)
+	}
+
+	r, err := db.GetReader("blob")
+	if err != nil {
+		t.Errorf("GetReader should not return error, but %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, blob) {
+		t.Errorf("GetReader should stream the whole value, but read %d bytes (%v)", len(got), err)
+	}
+
+	_, value, err := db.Get("blob")
+	if err != nil || !bytes.Equal(value, blob) {
+		t.Errorf("Get should assemble chunked values, but read %d bytes (%v)", len(value), err)
+	}
+}
+
+func TestDBSetReaderInvalidKey(t *testing.T) {
+	uploads := 0
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads++
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	_, err := db.SetReader("", bytes.NewReader(make([]byte, DefaultChunkSize+1)))
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("SetReader should return ErrInvalidKey for an empty key, but %v", err)
+	}
+	if uploads != 0 {
+		t.Errorf("SetReader should validate the key before uploading, but %d requests", uploads)
+	}
+}
+
+func TestDBGetRange(t *testing.T) {
+	ipfs := testChunkIPFS()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	blob := make([]byte, DefaultChunkSize*2)
+	rand.Read(blob)
+	db.SetReader("blob", bytes.NewReader(blob))
+
+	offset, length := int64(DefaultChunkSize-10), int64(20)
+	r, err := db.GetRange("blob", offset, length)
+	if err != nil {
+		t.Errorf("GetRange should not return error, but %s", err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(got, blob[offset:offset+length]) {
+		t.Errorf("GetRange should read across chunk boundaries, but %x", got)
+	}
+
+	r, _ = db.GetRange("blob", int64(len(blob))-5, 100)
+	got, _ = ioutil.ReadAll(r)
+	if !bytes.Equal(got, blob[len(blob)-5:]) {
+		t.Errorf("GetRange should stop at the end of the value, but %x", got)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime/multipart"
 	"net/textproto"
 	"net/url"
@@ -40,195 +39,268 @@ type Message struct {
 	Data string
 }
 
+type Link struct {
+	Name string
+	Hash string
+	Size uint64
+}
+
+type Block struct {
+	Key  string
+	Size int
+}
+
+type ObjectStat struct {
+	Hash           string
+	NumLinks       int
+	BlockSize      int
+	LinksSize      int
+	DataSize       int
+	CumulativeSize int
+}
+
+type Pins struct {
+	Pins []string
+}
+
+type Identity struct {
+	ID        string
+	PublicKey string
+	Addresses []string
+}
+
+type Key struct {
+	Name string
+	Id   string
+}
+
+type KeyRename struct {
+	Was       string
+	Now       string
+	Id        string
+	Overwrite bool
+}
+
+// Node is a DAG node as accepted by object/put.
+type Node struct {
+	Data  string
+	Links []Link
+}
+
+type PinList struct {
+	Keys map[string]struct {
+		Type string
+	}
+}
+
 func (c Client) Add(name string, r io.Reader, opts RequestOptions) (string, error) {
 	mp, contentType, err := multiPartFromReader(name, r)
 	if err != nil {
 		return "", err
 	}
+	return c.add(&mp, contentType, opts)
+}
 
+func (c Client) AddFiles(next func() (string, io.Reader, error), opts RequestOptions) (string, error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(multiPartFromFiles(w, next))
+	}()
+	hash, err := c.add(pr, w.FormDataContentType(), opts)
+	pr.CloseWithError(io.ErrClosedPipe)
+	return hash, err
+}
+
+func (c Client) add(body io.Reader, contentType string, opts RequestOptions) (string, error) {
 	req := NewRequest(c.ipfs.url, "add", opts)
-	req.Body = &mp
+	req.Body = body
 	req.Headers["Content-Type"] = contentType
 
-	resp, err := req.Send(c.ipfs.client)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Close()
-
-	if resp.Error != nil {
-		return "", resp.Error
-	}
-
-	dec := json.NewDecoder(resp.Output)
 	var final string
-	for {
-		var out Object
-		err = dec.Decode(&out)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", err
-		}
+	err := each(c, req, func(out Object) error {
 		final = out.Hash
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
-
 	if final == "" {
 		return "", errors.New("no results received")
 	}
-
 	return final, nil
 }
 
 func (c Client) ObjectPatchAddLink(root, name, ref string, opts RequestOptions) (string, error) {
-	req := NewRequest(c.ipfs.url, "object/patch/add-link", opts, root, name, ref)
-	resp, err := req.Send(c.ipfs.client)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Close()
-
-	if resp.Error != nil {
-		return "", resp.Error
-	}
-
-	var out Object
-	err = json.NewDecoder(resp.Output).Decode(&out)
-	if err != nil {
-		return "", err
-	}
-
-	return out.Hash, nil
+	out, err := exec[Object](c, "object/patch/add-link", []string{root, name, ref}, opts)
+	return out.Hash, err
 }
 
 func (c Client) ObjectPatchRmLink(root, name string, opts RequestOptions) (string, error) {
-	req := NewRequest(c.ipfs.url, "object/patch/rm-link", opts, root, name)
-	resp, err := req.Send(c.ipfs.client)
+	out, err := exec[Object](c, "object/patch/rm-link", []string{root, name}, opts)
+	return out.Hash, err
+}
+
+func (c Client) ObjectPut(node Node, opts RequestOptions) (string, error) {
+	data, err := json.Marshal(node)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Close()
-
-	if resp.Error != nil {
-		return "", resp.Error
-	}
-
-	var out Object
-	err = json.NewDecoder(resp.Output).Decode(&out)
+	mp, contentType, err := multiPartFromReader("node", bytes.NewReader(data))
 	if err != nil {
 		return "", err
 	}
-
-	return out.Hash, nil
+	req := NewRequest(c.ipfs.url, "object/put", opts)
+	req.Body = &mp
+	req.Headers["Content-Type"] = contentType
+	out, err := send[Object](c, req)
+	return out.Hash, err
 }
 
 func (c Client) Cat(path string, opts RequestOptions) ([]byte, error) {
-	req := NewRequest(c.ipfs.url, "cat", opts, path)
-	resp, err := req.Send(c.ipfs.client)
+	out, err := exec[[]byte](c, "cat", []string{path}, opts)
 	if err != nil {
 		return []byte{}, err
 	}
-	defer resp.Close()
-
-	if resp.Error != nil {
-		return []byte{}, resp.Error
-	}
-
-	return ioutil.ReadAll(resp.Output)
+	return out, nil
 }
 
 func (c Client) KeyGen(name string, opts RequestOptions) error {
-	req := NewRequest(c.ipfs.url, "key/gen", opts, name)
-	resp, err := req.Send(c.ipfs.client)
-	if err != nil {
-		return err
-	}
-	defer resp.Close()
-
-	if resp.Error != nil {
-		return resp.Error
-	}
-	return nil
+	_, err := exec[None](c, "key/gen", []string{name}, opts)
+	return err
 }
 
-func (c Client) NamePublish(hash string, opts RequestOptions) (string, string, error) {
-	req := NewRequest(c.ipfs.url, "name/publish", opts, hash)
-	resp, err := req.Send(c.ipfs.client)
+func (c Client) KeyList(opts RequestOptions) ([]Key, error) {
+	out, err := exec[struct{ Keys []Key }](c, "key/list", nil, opts)
 	if err != nil {
-		return "", "", err
+		return []Key{}, err
 	}
-	defer resp.Close()
+	return out.Keys, nil
+}
 
-	if resp.Error != nil {
-		return "", "", err
-	}
+func (c Client) KeyRename(oldname, newname string, opts RequestOptions) (KeyRename, error) {
+	return exec[KeyRename](c, "key/rename", []string{oldname, newname}, opts)
+}
 
-	var out IPNS
-	err = json.NewDecoder(resp.Output).Decode(&out)
+func (c Client) KeyRm(name string, opts RequestOptions) ([]Key, error) {
+	out, err := exec[struct{ Keys []Key }](c, "key/rm", []string{name}, opts)
 	if err != nil {
-		return "", "", err
+		return []Key{}, err
 	}
-	return out.Name, out.Value, nil
+	return out.Keys, nil
+}
+
+func (c Client) NamePublish(hash string, opts RequestOptions) (string, string, error) {
+	out, err := exec[IPNS](c, "name/publish", []string{hash}, opts)
+	return out.Name, out.Value, err
 }
 
 func (c Client) NameResolve(name string, opts RequestOptions) (string, error) {
-	req := NewRequest(c.ipfs.url, "name/resolve", opts, name)
-	resp, err := req.Send(c.ipfs.client)
+	out, err := exec[IPNS](c, "name/resolve", []string{name}, opts)
+	return out.Path, err
+}
+
+func (c Client) PubSubPub(topic, payload string, opts RequestOptions) error {
+	_, err := exec[None](c, "pubsub/pub", []string{topic, payload}, opts)
+	return err
+}
+
+func (c Client) PubSubSub(topic string, opts RequestOptions) (Stream, error) {
+	resp, err := c.call(NewRequest(c.ipfs.url, "pubsub/sub", opts, topic))
 	if err != nil {
-		return "", err
+		return Stream{}, err
 	}
-	defer resp.Close()
 
-	if resp.Error != nil {
-		return "", err
+	stream := Stream{
+		Data: make(chan string),
+		src:  resp.Output,
 	}
+	go stream.read()
+	return stream, nil
+}
 
-	var out IPNS
-	err = json.NewDecoder(resp.Output).Decode(&out)
+func (c Client) PinAdd(hash string, opts RequestOptions) ([]string, error) {
+	out, err := exec[Pins](c, "pin/add", []string{hash}, opts)
 	if err != nil {
-		return "", err
+		return []string{}, err
 	}
-	return out.Path, nil
+	return out.Pins, nil
 }
 
-func (c Client) PubSubPub(topic, payload string, opts RequestOptions) error {
-	req := NewRequest(c.ipfs.url, "pubsub/pub", opts, topic, payload)
-	resp, err := req.Send(c.ipfs.client)
+func (c Client) PinRm(hash string, opts RequestOptions) ([]string, error) {
+	out, err := exec[Pins](c, "pin/rm", []string{hash}, opts)
 	if err != nil {
-		return err
+		return []string{}, err
 	}
-	defer resp.Close()
+	return out.Pins, nil
+}
 
-	if resp.Error != nil {
-		return err
+func (c Client) PinLs(hash string, opts RequestOptions) (map[string]string, error) {
+	out, err := exec[PinList](c, "pin/ls", []string{hash}, opts)
+	if err != nil {
+		return map[string]string{}, err
+	}
+	pins := make(map[string]string, len(out.Keys))
+	for k, v := range out.Keys {
+		pins[k] = v.Type
 	}
-	return nil
+	return pins, nil
 }
 
-func (c Client) PubSubSub(topic string, opts RequestOptions) (Stream, error) {
-	req := NewRequest(c.ipfs.url, "pubsub/sub", opts, topic)
-	resp, err := req.Send(c.ipfs.client)
+func (c Client) ObjectLinks(hash string, opts RequestOptions) ([]Link, error) {
+	out, err := exec[struct {
+		Hash  string
+		Links []Link
+	}](c, "object/links", []string{hash}, opts)
 	if err != nil {
-		return Stream{}, err
+		return []Link{}, err
 	}
+	return out.Links, nil
+}
 
-	if resp.Error != nil {
-		return Stream{}, err
-	}
+func (c Client) ObjectStat(hash string, opts RequestOptions) (ObjectStat, error) {
+	return exec[ObjectStat](c, "object/stat", []string{hash}, opts)
+}
 
-	stream := Stream{
-		Data: make(chan string),
-		src:  resp.Output,
-	}
-	go stream.read()
-	return stream, nil
+func (c Client) BlockStat(hash string, opts RequestOptions) (Block, error) {
+	return exec[Block](c, "block/stat", []string{hash}, opts)
+}
+
+func (c Client) ID(opts RequestOptions) (Identity, error) {
+	return exec[Identity](c, "id", nil, opts)
 }
 
 func multiPartFromReader(name string, r io.Reader) (bytes.Buffer, string, error) {
 	var b bytes.Buffer
 	w := multipart.NewWriter(&b)
 
+	err := writeFilePart(w, name, r)
+	if err != nil {
+		return b, "", err
+	}
+
+	w.Close()
+
+	return b, w.FormDataContentType(), nil
+}
+
+func multiPartFromFiles(w *multipart.Writer, next func() (string, io.Reader, error)) error {
+	for {
+		name, r, err := next()
+		if err == io.EOF {
+			return w.Close()
+		}
+		if err != nil {
+			return err
+		}
+		err = writeFilePart(w, name, r)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeFilePart(w *multipart.Writer, name string, r io.Reader) error {
 	h := make(textproto.MIMEHeader)
 	h.Set("Content-Disposition",
 		fmt.Sprintf(`form-data; name="file"; filename="%s"`, url.QueryEscape(name)))
@@ -236,15 +308,9 @@ func multiPartFromReader(name string, r io.Reader) (bytes.Buffer, string, error)
 
 	pw, err := w.CreatePart(h)
 	if err != nil {
-		return b, "", err
+		return err
 	}
 
 	_, err = io.Copy(pw, r)
-	if err != nil {
-		return b, "", err
-	}
-
-	w.Close()
-
-	return b, w.FormDataContentType(), nil
+	return err
 }
@@ -144,9 +144,127 @@ func TestClientNameResolve(t *testing.T) {
 	}
 }
 
+func TestClientPinAdd(t *testing.T) {
+	expect := "QmSomeObjectHash"
+
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fmt.Sprintf(`{"Pins":["%s"]}`, expect))
+	}))
+	defer ipfs.Close()
+
+	client := testClient(ipfs.URL)
+	pins, err := client.PinAdd(expect, RequestOptions{"recursive": "true"})
+
+	if err != nil {
+		t.Errorf("PinAdd should not return error, but %s", err)
+	}
+	if len(pins) != 1 || pins[0] != expect {
+		t.Errorf("PinAdd should return pinned hash (%s), but %v", expect, pins)
+	}
+}
+
+func TestClientObjectLinks(t *testing.T) {
+	expect := "QmSomeLinkHash"
+
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fmt.Sprintf(`{"Hash":"QmSomeRootHash","Links":[{"Name":"key","Hash":"%s","Size":67}]}`, expect))
+	}))
+	defer ipfs.Close()
+
+	client := testClient(ipfs.URL)
+	links, err := client.ObjectLinks("QmSomeRootHash", RequestOptions{})
+
+	if err != nil {
+		t.Errorf("ObjectLinks should not return error, but %s", err)
+	}
+	if len(links) != 1 || links[0].Name != "key" || links[0].Hash != expect {
+		t.Errorf("ObjectLinks should return links (%s), but %v", expect, links)
+	}
+}
+
 func testClient(url string) Client {
 	ipfs, _ := NewIPFS(url)
 	return Client{
 		ipfs: ipfs,
 	}
 }
+
+func TestClientErrorResponses(t *testing.T) {
+	responses := []struct {
+		status      int
+		contentType string
+		body        string
+		message     string
+	}{
+		{http.StatusBadRequest, "text/plain", "argument \"key\" is required", "argument \"key\" is required"},
+		{http.StatusInternalServerError, "application/json", `{"Message":"routing: not found","Code":0}`, "routing: not found"},
+	}
+	calls := map[string]func(c Client) error{
+		"NamePublish": func(c Client) error {
+			_, _, err := c.NamePublish("QmHash", RequestOptions{})
+			return err
+		},
+		"NameResolve": func(c Client) error {
+			_, err := c.NameResolve("QmName", RequestOptions{})
+			return err
+		},
+		"PubSubPub": func(c Client) error {
+			return c.PubSubPub("topic", "payload", RequestOptions{})
+		},
+		"PubSubSub": func(c Client) error {
+			_, err := c.PubSubSub("topic", RequestOptions{})
+			return err
+		},
+		"KeyGen": func(c Client) error {
+			return c.KeyGen("name", RequestOptions{})
+		},
+		"Cat": func(c Client) error {
+			_, err := c.Cat("QmHash", RequestOptions{})
+			return err
+		},
+		"ObjectLinks": func(c Client) error {
+			_, err := c.ObjectLinks("QmHash", RequestOptions{})
+			return err
+		},
+	}
+	for _, res := range responses {
+		ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", res.contentType)
+			w.WriteHeader(res.status)
+			fmt.Fprint(w, res.body)
+		}))
+		for name, call := range calls {
+			err := call(testClient(ipfs.URL))
+			e, ok := err.(*Error)
+			if !ok || e.Message != res.message {
+				t.Errorf("%s should return the daemon error %q for status %d, but %v", name, res.message, res.status, err)
+			}
+		}
+		ipfs.Close()
+	}
+}
+
+func TestClientStreamError(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Stream-Error")
+		switch r.URL.Path {
+		case "/api/v0/cat":
+			fmt.Fprint(w, "partial")
+		case "/api/v0/add":
+			fmt.Fprintln(w, `{"Name":"file.txt","Hash":"QmSomeObjectHash","Size":"13"}`)
+		}
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Stream-Error", "context canceled")
+	}))
+	defer ipfs.Close()
+
+	client := testClient(ipfs.URL)
+	_, err := client.Cat("QmHash", RequestOptions{})
+	if e, ok := err.(*Error); !ok || e.Message != "context canceled" || e.Command != "cat" {
+		t.Errorf("Cat should return the error in the X-Stream-Error trailer, but %v", err)
+	}
+	_, err = client.Add("file.txt", strings.NewReader("some value"), RequestOptions{})
+	if e, ok := err.(*Error); !ok || e.Message != "context canceled" {
+		t.Errorf("Add should return the error in the X-Stream-Error trailer, but %v", err)
+	}
+}
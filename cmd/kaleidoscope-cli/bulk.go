@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+func load(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("load", out)
+	format := fs.String("format", "jsonl", "input format (jsonl|csv)")
+	batch := fs.Int("batch", 100, "number of records per batch")
+	dryRun := fs.Bool("dry-run", false, "parse input without writing")
+	err := parse(fs, args, 0, "")
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return UsageError{"Usage: load -d db [-format jsonl|csv] [-batch n] [-dry-run] file"}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var db *kaleidoscope.DB
+	if !*dryRun {
+		err = use(kes, *dbname)
+		if err != nil {
+			return err
+		}
+		db, err = kes.Current()
+		if err != nil {
+			return err
+		}
+	}
+
+	var records []kaleidoscope.Record
+	total := 0
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		if !*dryRun {
+			_, err := db.SetBatch(records)
+			if err != nil {
+				return err
+			}
+		}
+		total += len(records)
+		records = records[:0]
+		fmt.Fprintf(os.Stderr, "loaded %d records\n", total)
+		return nil
+	}
+	err = readRecords(f, *format, func(r kaleidoscope.Record) error {
+		records = append(records, r)
+		if len(records) < *batch {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	err = flush()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(os.Stderr, "dry run: %d records would be loaded\n", total)
+		return nil
+	}
+	return kes.Save()
+}
+
+func dump(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("dump", out)
+	format := fs.String("format", "jsonl", "output format (jsonl|csv)")
+	err := parse(fs, args, 0, "")
+	if err != nil {
+		return err
+	}
+
+	err = use(kes, *dbname)
+	if err != nil {
+		return err
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return err
+	}
+
+	f := os.Stdout
+	if fs.NArg() > 0 {
+		f, err = os.Create(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	w := bufio.NewWriter(f)
+	total := 0
+	write, done, err := recordWriter(w, *format)
+	if err != nil {
+		return err
+	}
+	err = db.Each(func(r kaleidoscope.Record) error {
+		total++
+		if total%100 == 0 {
+			fmt.Fprintf(os.Stderr, "dumped %d records\n", total)
+		}
+		return write(r)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "dumped %d records\n", total)
+	err = done()
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	if f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+func readRecords(r io.Reader, format string, fn func(kaleidoscope.Record) error) error {
+	switch format {
+	case "jsonl":
+		dec := json.NewDecoder(r)
+		for {
+			var rec kaleidoscope.Record
+			err := dec.Decode(&rec)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = fn(rec)
+			if err != nil {
+				return err
+			}
+		}
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		for line := 1; ; line++ {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if line == 1 && len(row) == 2 && row[0] == "key" && row[1] == "value" {
+				continue
+			}
+			if len(row) != 2 {
+				return fmt.Errorf("line %d: expected key,value but %d fields", line, len(row))
+			}
+			err = fn(kaleidoscope.Record{Key: row[0], Value: row[1]})
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unknown format: %s", format)
+	}
+}
+
+func recordWriter(w io.Writer, format string) (func(kaleidoscope.Record) error, func() error, error) {
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		write := func(r kaleidoscope.Record) error {
+			return enc.Encode(r)
+		}
+		return write, func() error { return nil }, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{"key", "value"})
+		if err != nil {
+			return nil, nil, err
+		}
+		write := func(r kaleidoscope.Record) error {
+			if r.Collection != "" {
+				return fmt.Errorf("csv can not hold %s of collection %s, use jsonl", r.Key, r.Collection)
+			}
+			return cw.Write([]string{r.Key, r.Value})
+		}
+		done := func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		return write, done, nil
+	default:
+		return nil, nil, fmt.Errorf("Unknown format: %s", format)
+	}
+}
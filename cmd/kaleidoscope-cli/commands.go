@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitNotFound
+	exitNoDatabase
+	exitConflict
+	exitDecrypt
+	exitUnavailable
+	exitInvalidKey
+)
+
+type subcommand func(kes *kaleidoscope.Kaleidoscope, args []string, out output) error
+
+var subcommands = map[string]subcommand{
+	"create": createCommand,
+	"get":    getCommand,
+	"set":    setCommand,
+	"del":    delCommand,
+	"save":   saveCommand,
+	"index":  indexCommand,
+	"query":  queryCommand,
+	"list":   listCommand,
+	"rename": renameCommand,
+	"drop":   dropCommand,
+	"load": func(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+		return load(kes, args, out)
+	},
+	"dump": func(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+		return dump(kes, args, out)
+	},
+}
+
+type UsageError struct {
+	Message string
+}
+
+func (e UsageError) Error() string {
+	return e.Message
+}
+
+// output is shared by the global and the subcommand flag sets, so -json is
+// honoured before the subcommand and among its flags.
+type output struct {
+	json *bool
+	w    io.Writer
+}
+
+func (o output) print(text string, v interface{}) error {
+	if *o.json {
+		return json.NewEncoder(o.w).Encode(v)
+	}
+	if text == "" {
+		return nil
+	}
+	_, err := fmt.Fprintln(o.w, text)
+	return err
+}
+
+func execute(kes *kaleidoscope.Kaleidoscope, args []string) int {
+	out := output{json: new(bool), w: os.Stdout}
+	fs := flag.NewFlagSet("kaleidoscope-cli", flag.ContinueOnError)
+	fs.BoolVar(out.json, "json", false, "print results as JSON")
+	err := parse(fs, args, 1, "kaleidoscope-cli [-json] command [flags] [args]\nCommands: "+commandNames())
+	if err == nil {
+		args = fs.Args()
+		cmd, ok := subcommands[args[0]]
+		if !ok {
+			err = UsageError{fmt.Sprintf("Unknown command: %s\nCommands: %s", args[0], commandNames())}
+		} else {
+			err = cmd(kes, args[1:], out)
+		}
+	}
+	if err == nil {
+		return exitOK
+	}
+	if err == flag.ErrHelp {
+		return exitUsage
+	}
+
+	if *out.json {
+		json.NewEncoder(os.Stderr).Encode(map[string]string{"error": err.Error()})
+	} else {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	return exitCode(err)
+}
+
+func exitCode(err error) int {
+	if _, ok := err.(UsageError); ok {
+		return exitUsage
+	}
+	codes := []struct {
+		err  error
+		code int
+	}{
+		{kaleidoscope.ErrNotFound, exitNotFound},
+		{kaleidoscope.ErrNoDatabase, exitNoDatabase},
+		{kaleidoscope.ErrConflict, exitConflict},
+		{kaleidoscope.ErrDecrypt, exitDecrypt},
+		{kaleidoscope.ErrDaemonUnavailable, exitUnavailable},
+		{kaleidoscope.ErrInvalidKey, exitInvalidKey},
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return exitError
+}
+
+func commandNames() string {
+	return "create, list, rename, drop, get, set, del, save, index, query, load, dump"
+}
+
+func newFlagSet(name string, out output) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	dbname := fs.String("d", "", "database name")
+	fs.BoolVar(out.json, "json", *out.json, "print results as JSON")
+	return fs, dbname
+}
+
+// parse stops at the first argument that is not a flag, so values that look
+// like flags are kept as arguments.
+func parse(fs *flag.FlagSet, args []string, nargs int, usage string) error {
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		return err
+	}
+	if err != nil {
+		return UsageError{err.Error()}
+	}
+	if fs.NArg() < nargs {
+		return UsageError{"Usage: " + usage}
+	}
+	return nil
+}
+
+func use(kes *kaleidoscope.Kaleidoscope, dbname string) error {
+	if dbname == "" {
+		dbname = os.Getenv("KALEIDOSCOPE_DB")
+	}
+	if dbname == "" {
+		return UsageError{"No database specified. Use -d or KALEIDOSCOPE_DB."}
+	}
+	err := kes.Use(dbname)
+	if err != nil {
+		return err
+	}
+	warnAhead(kes)
+	return nil
+}
+
+func warnAhead(kes *kaleidoscope.Kaleidoscope) {
+	db, err := kes.Current()
+	if err != nil {
+		return
+	}
+	r := db.Recovery()
+	if r.Local() {
+		fmt.Fprintf(os.Stderr, "warning: resolving %s timed out, using the local head (%s)\n", db.Name(), r.Head)
+	}
+	if r.Ahead() {
+		fmt.Fprintf(os.Stderr, "warning: local head of %s (%s) is ahead of the published record (%s), %d operation(s) recovered; run save to publish\n",
+			db.Name(), r.Head, r.Published, r.Replayed)
+	}
+}
+
+func createCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, _ := newFlagSet("create", out)
+	size := fs.Int("size", 2048, "RSA key size")
+	err := parse(fs, args, 1, "create [-size bits] [-json] db")
+	if err != nil {
+		return err
+	}
+	hash, err := kes.Create(fs.Arg(0), *size)
+	if err != nil {
+		return err
+	}
+	err = kes.Save()
+	if err != nil {
+		return err
+	}
+	return out.print(hash, map[string]string{"database": fs.Arg(0), "hash": hash})
+}
+
+func getCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("get", out)
+	offset := fs.Int64("offset", 0, "stream the value from the byte offset")
+	length := fs.Int64("length", -1, "stream at most length bytes of the value")
+	collection := fs.String("c", "", "collection of the key")
+	err := parse(fs, args, 1, "get -d db [-c collection] [-offset n] [-length n] [-json] key")
+	if err != nil {
+		return err
+	}
+	err = use(kes, *dbname)
+	if err != nil {
+		return err
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	if *collection != "" {
+		if *offset != 0 || *length >= 0 {
+			return UsageError{"-c can not be used with -offset or -length."}
+		}
+		ts, value, err := db.Collection(*collection).Get(key)
+		if err != nil {
+			return err
+		}
+		return out.print(string(value), kaleidoscope.Record{Key: key, Value: string(value), Timestamp: string(ts)})
+	}
+	if *offset != 0 || *length >= 0 {
+		r, err := db.GetRange(key, *offset, *length)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(out.w, r)
+		return err
+	}
+	v, err := db.Value(key)
+	if err != nil {
+		return err
+	}
+	return out.print(render(v), kaleidoscope.Record{Key: key, Value: string(v.Data), Timestamp: v.Timestamp, Expires: v.Expires, Codec: v.Codec})
+}
+
+func render(v kaleidoscope.Value) string {
+	switch v.Codec {
+	case kaleidoscope.JSONCodec.Name():
+		var buf bytes.Buffer
+		if json.Indent(&buf, v.Data, "", "  ") == nil {
+			return buf.String()
+		}
+	case kaleidoscope.GobCodec.Name(), kaleidoscope.ProtoCodec.Name():
+		return strings.TrimRight(hex.Dump(v.Data), "\n")
+	}
+	return string(v.Data)
+}
+
+func setCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("set", out)
+	file := fs.String("file", "", "read value from file ('-' for stdin)")
+	save := fs.Bool("save", true, "publish the database after writing")
+	ttl := fs.Duration("ttl", 0, "expire the value after the duration")
+	stream := fs.Bool("stream", false, "upload the value in encrypted chunks")
+	collection := fs.String("c", "", "collection of the key")
+	err := parse(fs, args, 1, "set -d db [-c collection] [-file path|-] [-ttl duration|-stream] [-save=false] [-json] key [value]")
+	if err != nil {
+		return err
+	}
+	if *stream && *ttl > 0 {
+		return UsageError{"-stream and -ttl can not be used together."}
+	}
+	if *collection != "" && (*stream || *ttl > 0) {
+		return UsageError{"-c can not be used with -stream or -ttl."}
+	}
+
+	key := fs.Arg(0)
+	var src io.Reader
+	switch {
+	case *file == "-" || (*file == "" && fs.NArg() == 1):
+		src = os.Stdin
+	case *file != "":
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	default:
+		src = strings.NewReader(strings.Join(fs.Args()[1:], " "))
+	}
+
+	err = use(kes, *dbname)
+	if err != nil {
+		return err
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return err
+	}
+	var hash string
+	if *stream {
+		hash, err = db.SetReader(key, src)
+	} else {
+		var data []byte
+		data, err = ioutil.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		if *collection != "" {
+			hash, err = db.Collection(*collection).Set(key, string(data))
+		} else if *ttl > 0 {
+			hash, err = db.SetWithTTL(key, string(data), *ttl)
+		} else {
+			hash, err = db.Set(key, string(data))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *save {
+		err = kes.Save()
+		if err != nil {
+			return err
+		}
+	}
+	return out.print(hash, map[string]string{"key": key, "hash": hash})
+}
+
+func delCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("del", out)
+	save := fs.Bool("save", true, "publish the database after deleting")
+	collection := fs.String("c", "", "collection of the key")
+	err := parse(fs, args, 1, "del -d db [-c collection] [-save=false] [-json] key")
+	if err != nil {
+		return err
+	}
+	err = use(kes, *dbname)
+	if err != nil {
+		return err
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	var hash string
+	if *collection != "" {
+		hash, err = db.Collection(*collection).Del(key)
+	} else {
+		hash, err = db.Del(key)
+	}
+	if err != nil {
+		return err
+	}
+	if *save {
+		err = kes.Save()
+		if err != nil {
+			return err
+		}
+	}
+	return out.print(hash, map[string]string{"key": key, "hash": hash})
+}
+
+func saveCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("save", out)
+	err := parse(fs, args, 0, "save -d db [-json]")
+	if err != nil {
+		return err
+	}
+	err = use(kes, *dbname)
+	if err != nil {
+		return err
+	}
+	err = kes.Save()
+	if err != nil {
+		return err
+	}
+	return out.print("", map[string]string{"database": *dbname, "status": "saved"})
+}
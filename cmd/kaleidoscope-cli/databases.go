@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+func listCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, _ := newFlagSet("list", out)
+	err := parse(fs, args, 0, "list [-json]")
+	if err != nil {
+		return err
+	}
+	dbs, err := kes.Databases()
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, db := range dbs {
+		head := db.Head
+		if head == "" {
+			head = "-"
+		}
+		lines = append(lines, strings.Join([]string{db.Name, db.ID, head}, "\t"))
+	}
+	return out.print(strings.Join(lines, "\n"), dbs)
+}
+
+func renameCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, _ := newFlagSet("rename", out)
+	save := fs.Bool("save", true, "publish the database under its new name")
+	err := parse(fs, args, 2, "rename [-save=false] [-json] old new")
+	if err != nil {
+		return err
+	}
+	oldname, newname := fs.Arg(0), fs.Arg(1)
+	db, err := kes.Rename(oldname, newname)
+	if err != nil {
+		return err
+	}
+	if *save {
+		err = db.Save()
+		if err != nil {
+			return err
+		}
+	}
+	return out.print(fmt.Sprintf("%s renamed to %s", oldname, newname),
+		map[string]string{"was": oldname, "database": newname, "head": db.Head()})
+}
+
+func dropCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, _ := newFlagSet("drop", out)
+	force := fs.Bool("force", false, "confirm that the key and local state of the database are removed")
+	err := parse(fs, args, 1, "drop -force [-json] db")
+	if err != nil {
+		return err
+	}
+	dbname := fs.Arg(0)
+	if !*force {
+		return UsageError{fmt.Sprintf("Dropping %s removes its key and can not be undone. Use -force to confirm.", dbname)}
+	}
+	err = kes.Drop(dbname)
+	if err != nil {
+		return err
+	}
+	return out.print(fmt.Sprintf("%s dropped", dbname), map[string]string{"database": dbname, "status": "dropped"})
+}
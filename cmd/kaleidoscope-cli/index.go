@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+const indexUsage = "index -d db [-save=false] [-json] list|define name field|drop name|rebuild [name]|find name value|range [-min v] [-max v] name"
+
+func indexCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("index", out)
+	save := fs.Bool("save", true, "publish the database after changing indexes")
+	min := fs.String("min", "", "lower bound of range (inclusive)")
+	max := fs.String("max", "", "upper bound of range (inclusive)")
+	err := parse(fs, args, 1, indexUsage)
+	if err != nil {
+		return err
+	}
+	err = use(kes, *dbname)
+	if err != nil {
+		return err
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return err
+	}
+
+	action, rest := fs.Arg(0), fs.Args()[1:]
+	arity := map[string]int{"list": 0, "define": 2, "drop": 1, "rebuild": 0, "find": 2, "range": 1}
+	if n, ok := arity[action]; !ok || len(rest) < n {
+		return UsageError{"Usage: " + indexUsage}
+	}
+
+	switch action {
+	case "list":
+		indexes := db.Indexes()
+		names := make([]string, 0, len(indexes))
+		for name := range indexes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines := []string{}
+		for _, name := range names {
+			lines = append(lines, name+"\t"+indexes[name])
+		}
+		return out.print(strings.Join(lines, "\n"), indexes)
+	case "find", "range":
+		var keys []string
+		if action == "find" {
+			keys, err = db.Query(rest[0], kaleidoscope.Literal(rest[1]))
+		} else {
+			keys, err = db.QueryRange(rest[0], bound(*min), bound(*max))
+		}
+		if err != nil {
+			return err
+		}
+		return out.print(strings.Join(keys, "\n"), map[string][]string{"keys": keys})
+	}
+
+	n := 0
+	switch action {
+	case "define":
+		n, err = db.DefineIndex(rest[0], rest[1])
+	case "drop":
+		err = db.DropIndex(rest[0])
+	case "rebuild":
+		if len(rest) > 0 {
+			n, err = db.RebuildIndex(rest[0])
+		} else {
+			n, err = db.RebuildIndexes()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *save {
+		err = db.Save()
+		if err != nil {
+			return err
+		}
+	}
+	return out.print(fmt.Sprintf("%d keys indexed", n), map[string]interface{}{"action": action, "indexed": n, "head": db.Head()})
+}
+
+func bound(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return kaleidoscope.Literal(s)
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/csv"
 	"fmt"
-	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/monochromegane/kaleidoscope"
 )
@@ -17,72 +15,117 @@ func main() {
 	kes, err := kaleidoscope.New()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+		os.Exit(exitError)
 	}
+	kes.SetNameOptions(nameOptions())
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Print("> ")
-		scanner.Scan()
-		r := csv.NewReader(bytes.NewReader(scanner.Bytes()))
-		r.Comma = ' '
-		record, err := r.Read()
-		if err != nil && err != io.EOF {
-			fmt.Print(err.Error())
-			continue
-		}
-		commands := compact(record)
-		if len(commands) == 0 {
-			continue
-		}
-		out, err := run(&kes, commands)
+	if len(os.Args) > 1 {
+		os.Exit(execute(kes, os.Args[1:]))
+	}
+
+	err = shell(kes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(exitError)
+	}
+}
+
+// nameOptions reads the IPNS options from the environment, using the same
+// variables as the server.
+func nameOptions() kaleidoscope.NameOptions {
+	duration := func(name string) time.Duration {
+		d, _ := time.ParseDuration(os.Getenv(name))
+		return d
+	}
+	return kaleidoscope.NameOptions{
+		ResolveTimeout: duration("KALEIDOSCOPE_RESOLVE_TIMEOUT"),
+		Recursive:      os.Getenv("KALEIDOSCOPE_RESOLVE_RECURSIVE") == "true",
+		Cache:          os.Getenv("KALEIDOSCOPE_RESOLVE_CACHE") == "true",
+		Fallback:       os.Getenv("KALEIDOSCOPE_RESOLVE_FALLBACK") == "true",
+		Lifetime:       duration("KALEIDOSCOPE_IPNS_LIFETIME"),
+		TTL:            duration("KALEIDOSCOPE_IPNS_TTL"),
+		AllowOffline:   os.Getenv("KALEIDOSCOPE_ALLOW_OFFLINE") == "true",
+	}
+}
+
+func pin(kes *kaleidoscope.Kaleidoscope, commands []string) (string, error) {
+	if len(commands) == 0 {
+		return "", fmt.Errorf("Usage: pin status|repair")
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(commands[0]) {
+	case "status":
+		status, err := db.PinStatus()
 		if err != nil {
-			if _, ok := err.(ExitError); ok {
-				break
+			return "", err
+		}
+		var out bytes.Buffer
+		fmt.Fprintf(&out, "root %s pinned=%t\n", status.Root, status.Pinned)
+		for _, v := range status.Values {
+			if v.Collection != "" {
+				fmt.Fprintf(&out, "%s %s available=%t collection=%s\n", v.Key, v.Hash, v.Available, v.Collection)
+				continue
 			}
-			fmt.Println(err.Error())
-			continue
+			fmt.Fprintf(&out, "%s %s available=%t\n", v.Key, v.Hash, v.Available)
+		}
+		if status.Healthy() {
+			out.WriteString("ok")
+		} else {
+			out.WriteString("missing blocks or pins, run `pin repair`")
 		}
-		if out != "" {
-			fmt.Println(out)
+		return out.String(), nil
+	case "repair":
+		err := db.RepairPins()
+		if err != nil {
+			return "", err
 		}
+		return "repaired", nil
+	default:
+		return "", fmt.Errorf("Unknown pin command: %s", commands[0])
 	}
 }
 
-func run(kes *kaleidoscope.Kaleidoscope, commands []string) (string, error) {
-	switch strings.ToLower(commands[0]) {
-	case "create":
-		dbname := commands[1]
-		size := 2048
-		if len(commands) > 2 {
-			s := commands[2]
-			is, err := strconv.Atoi(s)
-			if err != nil {
-				return "", err
-			}
-			size = is
+func compactDatabase(kes *kaleidoscope.Kaleidoscope, commands []string) (string, error) {
+	versions := kaleidoscope.DefaultRetention - 1
+	if len(commands) > 0 {
+		v, err := strconv.Atoi(commands[0])
+		if err != nil {
+			return "", err
 		}
-		return kes.Create(dbname, size)
-	case "save":
-		return "", kes.Save()
-	case "use":
-		return "", kes.Use(commands[1])
-	case "set":
-		key := commands[1]
-		value := commands[2]
-		return kes.Set(key, value)
-	case "get":
-		_, out, err := kes.Get(commands[1])
-		return string(out), err
-	case "del":
-		return kes.Del(commands[1])
-	case "sync":
-		return "", kes.StartSync()
-	case "exit":
-		return "", ExitError{}
-	default:
-		return "", fmt.Errorf("Unknown command: %s", commands[0])
+		versions = v
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return "", err
+	}
+	report, err := db.Compact(versions)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("kept %d versions, unpinned %d objects, reclaimed %d blocks (%d bytes)",
+		len(report.Roots), len(report.Unpinned), report.Blocks, report.Bytes), nil
+}
+
+func export(kes *kaleidoscope.Kaleidoscope, commands []string) (string, error) {
+	if len(commands) == 0 {
+		return "", fmt.Errorf("Usage: export file [json]")
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(commands[0])
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if len(commands) > 1 && strings.ToLower(commands[1]) == "json" {
+		return "", db.ExportJSON(f)
 	}
+	return "", db.Export(f)
 }
 
 type ExitError struct {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+const queryUsage = "query -d db [-key glob] [-regexp re] [-where expr]... [-after t] [-before t] [-writer peer] [-limit n] [-offset n] [-json]"
+
+type predicates []kaleidoscope.Predicate
+
+func (p *predicates) String() string {
+	exprs := []string{}
+	for _, pred := range *p {
+		exprs = append(exprs, pred.String())
+	}
+	return strings.Join(exprs, ",")
+}
+
+func (p *predicates) Set(expr string) error {
+	pred, err := kaleidoscope.ParsePredicate(expr)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, pred)
+	return nil
+}
+
+func queryCommand(kes *kaleidoscope.Kaleidoscope, args []string, out output) error {
+	fs, dbname := newFlagSet("query", out)
+	glob := fs.String("key", "", "match keys against a glob pattern")
+	re := fs.String("regexp", "", "match keys against a regular expression")
+	var where predicates
+	fs.Var(&where, "where", "JSON value predicate such as age>=30 (repeatable)")
+	after := fs.String("after", "", "written at or after the time (RFC3339 or duration ago)")
+	before := fs.String("before", "", "written before the time (RFC3339 or duration ago)")
+	writer := fs.String("writer", "", "written by the peer ID")
+	limit := fs.Int("limit", 0, "return at most n records")
+	offset := fs.Int("offset", 0, "skip the first n matching records")
+	err := parse(fs, args, 0, queryUsage)
+	if err != nil {
+		return err
+	}
+
+	filter := kaleidoscope.Filter{Glob: *glob, Regexp: *re, Where: where, Writer: *writer, Limit: *limit, Offset: *offset}
+	filter.After, err = moment(*after)
+	if err != nil {
+		return UsageError{fmt.Sprintf("Invalid -after: %s", err)}
+	}
+	filter.Before, err = moment(*before)
+	if err != nil {
+		return UsageError{fmt.Sprintf("Invalid -before: %s", err)}
+	}
+
+	err = use(kes, *dbname)
+	if err != nil {
+		return err
+	}
+	db, err := kes.Current()
+	if err != nil {
+		return err
+	}
+	results, err := db.Find(filter)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	enc := json.NewEncoder(out.w)
+	for results.Next() {
+		record := results.Record()
+		if *out.json {
+			err = enc.Encode(record)
+		} else {
+			_, err = fmt.Fprintf(out.w, "%s\t%s\n", record.Key, record.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return results.Err()
+}
+
+func moment(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
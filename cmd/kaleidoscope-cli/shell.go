@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/readline"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/monochromegane/kaleidoscope"
+)
+
+const historyFile = "~/.kaleidoscope_history"
+
+type command struct {
+	name     string
+	args     string
+	help     string
+	arity    int
+	complete func(kes *kaleidoscope.Kaleidoscope) []string
+	run      func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error)
+}
+
+var shellCommands []command
+
+func init() {
+	shellCommands = []command{
+		{name: "create", args: "db [size]", help: "create a new database and use it", arity: 1,
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				size := 2048
+				if len(args) > 1 {
+					s, err := strconv.Atoi(args[1])
+					if err != nil {
+						return "", err
+					}
+					size = s
+				}
+				return kes.Create(args[0], size)
+			}},
+		{name: "use", args: "db", help: "switch to an existing database", arity: 1,
+			complete: databaseNames,
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				err := kes.Use(args[0])
+				if err != nil {
+					return "", err
+				}
+				warnAhead(kes)
+				return "", nil
+			}},
+		{name: "get", args: "key", help: "print the value of key", arity: 1,
+			complete: keyNames,
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				db, err := kes.Current()
+				if err != nil {
+					return "", err
+				}
+				v, err := db.Value(args[0])
+				if err != nil {
+					return "", err
+				}
+				return render(v), nil
+			}},
+		{name: "set", args: "key value", help: "set key to value", arity: 2,
+			complete: keyNames,
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				return kes.Set(args[0], args[1])
+			}},
+		{name: "del", args: "key", help: "delete key", arity: 1,
+			complete: keyNames,
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				return kes.Del(args[0])
+			}},
+		{name: "keys", args: "[collection]", help: "list keys in the current database or a collection",
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				db, err := kes.Current()
+				if err != nil {
+					return "", err
+				}
+				var keys []string
+				if len(args) > 0 {
+					keys, err = db.Collection(args[0]).Keys()
+				} else {
+					keys, err = db.Keys()
+				}
+				return strings.Join(keys, "\n"), err
+			}},
+		{name: "collections", help: "list collections in the current database",
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				db, err := kes.Current()
+				if err != nil {
+					return "", err
+				}
+				names, err := db.Collections()
+				return strings.Join(names, "\n"), err
+			}},
+		{name: "save", help: "publish the current head",
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				return "", kes.Save()
+			}},
+		{name: "sync", help: "start syncing with peers",
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				return "", kes.StartSync()
+			}},
+		{name: "pin", args: "status|repair", help: "inspect or repair pins of the current head", arity: 1,
+			complete: func(kes *kaleidoscope.Kaleidoscope) []string {
+				return []string{"status", "repair"}
+			},
+			run: pin},
+		{name: "compact", args: "[versions]", help: "unpin objects unreachable from recent versions",
+			run: compactDatabase},
+		{name: "export", args: "file [json]", help: "export the database to an archive", arity: 1,
+			run: export},
+		{name: "import", args: "file", help: "import an archive into the current database", arity: 1,
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				db, err := kes.Current()
+				if err != nil {
+					return "", err
+				}
+				f, err := os.Open(args[0])
+				if err != nil {
+					return "", err
+				}
+				defer f.Close()
+				return db.Import(f)
+			}},
+		{name: "help", args: "[command]", help: "show help",
+			run: help},
+		{name: "exit", help: "leave the shell",
+			run: func(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+				return "", ExitError{}
+			}},
+	}
+}
+
+func lookup(name string) (command, bool) {
+	for _, c := range shellCommands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func run(kes *kaleidoscope.Kaleidoscope, words []string) (string, error) {
+	name := strings.ToLower(words[0])
+	c, ok := lookup(name)
+	if !ok {
+		return "", fmt.Errorf("Unknown command: %s (try `help`)", words[0])
+	}
+	args := words[1:]
+	if len(args) < c.arity {
+		return "", fmt.Errorf("Usage: %s %s", c.name, c.args)
+	}
+	return c.run(kes, args)
+}
+
+func help(kes *kaleidoscope.Kaleidoscope, args []string) (string, error) {
+	var out bytes.Buffer
+	for _, c := range shellCommands {
+		if len(args) > 0 && c.name != args[0] {
+			continue
+		}
+		fmt.Fprintf(&out, "  %-24s %s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+	if out.Len() == 0 {
+		return "", fmt.Errorf("Unknown command: %s", args[0])
+	}
+	return strings.TrimRight(out.String(), "\n"), nil
+}
+
+func shell(kes *kaleidoscope.Kaleidoscope) error {
+	history, err := homedir.Expand(historyFile)
+	if err != nil {
+		return err
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          prompt(kes),
+		HistoryFile:     filepath.Clean(history),
+		AutoComplete:    completer(kes),
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		r := csv.NewReader(bytes.NewReader([]byte(line)))
+		r.Comma = ' '
+		record, err := r.Read()
+		if err != nil && err != io.EOF {
+			fmt.Println(err.Error())
+			continue
+		}
+		words := compact(record)
+		if len(words) == 0 {
+			continue
+		}
+		out, err := run(kes, words)
+		if err != nil {
+			if _, ok := err.(ExitError); ok {
+				return nil
+			}
+			fmt.Println(err.Error())
+			continue
+		}
+		if out != "" {
+			fmt.Println(out)
+		}
+		rl.SetPrompt(prompt(kes))
+	}
+}
+
+func prompt(kes *kaleidoscope.Kaleidoscope) string {
+	dbname := kes.Database()
+	if dbname == "" {
+		return "> "
+	}
+	head := kes.Head()
+	if len(head) > 8 {
+		head = head[len(head)-8:]
+	}
+	return fmt.Sprintf("%s@%s> ", dbname, head)
+}
+
+func completer(kes *kaleidoscope.Kaleidoscope) *readline.PrefixCompleter {
+	var items []readline.PrefixCompleterInterface
+	for _, c := range shellCommands {
+		if c.complete == nil {
+			items = append(items, readline.PcItem(c.name))
+			continue
+		}
+		complete := c.complete
+		items = append(items, readline.PcItem(c.name,
+			readline.PcItemDynamic(func(string) []string {
+				return complete(kes)
+			})))
+	}
+	return readline.NewPrefixCompleter(items...)
+}
+
+func databaseNames(kes *kaleidoscope.Kaleidoscope) []string {
+	dbs, err := kes.Databases()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(dbs))
+	for _, db := range dbs {
+		names = append(names, db.Name)
+	}
+	return names
+}
+
+func keyNames(kes *kaleidoscope.Kaleidoscope) []string {
+	db, err := kes.Current()
+	if err != nil {
+		return nil
+	}
+	keys, err := db.Keys()
+	if err != nil {
+		return nil
+	}
+	sort.Strings(keys)
+	return keys
+}
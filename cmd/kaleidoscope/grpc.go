@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/monochromegane/kaleidoscope"
+	"github.com/monochromegane/kaleidoscope/kaleidoscopepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type grpcServer struct {
+	kaleidoscopepb.UnimplementedKaleidoscopeServer
+	s    *server
+	sync bool
+}
+
+func (g grpcServer) database(dbname string) (*kaleidoscope.DB, error) {
+	kes, ok := g.s.database(dbname)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown database: %s", dbname)
+	}
+	return kes, nil
+}
+
+// grpcError maps the errors of a database to a status, so that clients only
+// retry the ones that may succeed later.
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, kaleidoscope.ErrInvalidKey):
+		code = codes.InvalidArgument
+	case errors.Is(err, kaleidoscope.ErrNotFound), errors.Is(err, kaleidoscope.ErrNoDatabase):
+		code = codes.NotFound
+	case errors.Is(err, kaleidoscope.ErrConflict):
+		code = codes.Aborted
+	case errors.Is(err, kaleidoscope.ErrDaemonUnavailable):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+func (g grpcServer) Create(ctx context.Context, req *kaleidoscopepb.CreateRequest) (*kaleidoscopepb.CreateResponse, error) {
+	size := int(req.Size)
+	if size == 0 {
+		size = 2048
+	}
+	hash, err := g.s.create(req.Database, size, g.sync)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kaleidoscopepb.CreateResponse{Hash: hash}, nil
+}
+
+func (g grpcServer) Use(ctx context.Context, req *kaleidoscopepb.UseRequest) (*kaleidoscopepb.UseResponse, error) {
+	kes, ok := g.s.database(req.Database)
+	if !ok {
+		err := g.s.open(req.Database, g.sync)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		kes, _ = g.s.database(req.Database)
+	}
+	return &kaleidoscopepb.UseResponse{Head: kes.Head()}, nil
+}
+
+func (g grpcServer) Get(ctx context.Context, req *kaleidoscopepb.GetRequest) (*kaleidoscopepb.GetResponse, error) {
+	kes, err := g.database(req.Database)
+	if err != nil {
+		return nil, err
+	}
+	meta, value, err := kes.Get(req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kaleidoscopepb.GetResponse{Meta: meta, Value: value}, nil
+}
+
+func (g grpcServer) Set(ctx context.Context, req *kaleidoscopepb.SetRequest) (*kaleidoscopepb.SetResponse, error) {
+	kes, err := g.database(req.Database)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := kes.Set(req.Key, req.Value)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kaleidoscopepb.SetResponse{Hash: hash}, nil
+}
+
+func (g grpcServer) Del(ctx context.Context, req *kaleidoscopepb.DelRequest) (*kaleidoscopepb.DelResponse, error) {
+	kes, err := g.database(req.Database)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := kes.Del(req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kaleidoscopepb.DelResponse{Hash: hash}, nil
+}
+
+func (g grpcServer) Save(ctx context.Context, req *kaleidoscopepb.SaveRequest) (*kaleidoscopepb.SaveResponse, error) {
+	kes, err := g.database(req.Database)
+	if err != nil {
+		return nil, err
+	}
+	err = kes.Save()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kaleidoscopepb.SaveResponse{Head: kes.Head()}, nil
+}
+
+func (g grpcServer) Watch(req *kaleidoscopepb.WatchRequest, stream kaleidoscopepb.Kaleidoscope_WatchServer) error {
+	kes, err := g.database(req.Database)
+	if err != nil {
+		return err
+	}
+	events, cancel := kes.Watch()
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			err := stream.Send(event(ev))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func event(ev kaleidoscope.Event) *kaleidoscopepb.Event {
+	return &kaleidoscopepb.Event{
+		Type:       ev.Type,
+		Database:   ev.Database,
+		Collection: ev.Collection,
+		Key:        ev.Key,
+		Hash:       ev.Hash,
+		Head:       ev.Head,
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/monochromegane/kaleidoscope"
+	"github.com/monochromegane/kaleidoscope/kaleidoscopepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{&kaleidoscope.KeyError{Key: "", Reason: "must not be empty"}, codes.InvalidArgument},
+		{&kaleidoscope.NotFoundError{Key: "key", Root: "QmRoot"}, codes.NotFound},
+		{&kaleidoscope.DatabaseError{Database: "db", Err: errors.New("no such file")}, codes.NotFound},
+		{fmt.Errorf("Key %s was modified: %w", "key", kaleidoscope.ErrConflict), codes.Aborted},
+		{fmt.Errorf("%w: connection refused", kaleidoscope.ErrDaemonUnavailable), codes.Unavailable},
+		{errors.New("some failure"), codes.Internal},
+	}
+	for _, c := range cases {
+		err := grpcError(c.err)
+		if status.Code(err) != c.code || status.Convert(err).Message() != c.err.Error() {
+			t.Errorf("grpcError(%v) should be %s, but %v", c.err, c.code, err)
+		}
+	}
+}
+
+func TestEvent(t *testing.T) {
+	ev := event(kaleidoscope.Event{Type: "set", Database: "db", Collection: "users", Key: "key", Hash: "QmValue", Head: "QmRoot"})
+	if ev.Type != "set" || ev.Database != "db" || ev.Collection != "users" ||
+		ev.Key != "key" || ev.Hash != "QmValue" || ev.Head != "QmRoot" {
+		t.Errorf("event should carry every field of the event, but %v", ev)
+	}
+}
+
+func testRemote(t *testing.T) *kaleidoscopepb.Remote {
+	s, _ := testServer(t)
+	l := bufconn.Listen(1 << 20)
+	gsrv := grpc.NewServer()
+	kaleidoscopepb.RegisterKaleidoscopeServer(gsrv, grpcServer{s: s})
+	go gsrv.Serve(l)
+	t.Cleanup(gsrv.Stop)
+
+	remote, err := kaleidoscopepb.Dial("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { remote.Close() })
+	return remote
+}
+
+func TestRemote(t *testing.T) {
+	remote := testRemote(t)
+
+	err := remote.Use("unknown")
+	if !errors.Is(err, kaleidoscope.ErrNotFound) {
+		t.Errorf("Use should return ErrNotFound for an unknown database, but %v", err)
+	}
+	_, err = remote.Create("dbname", 2048)
+	if err != nil {
+		t.Fatalf("Create should not return error, but %s", err)
+	}
+	_, err = remote.Create("dbname", 2048)
+	if !errors.Is(err, kaleidoscope.ErrConflict) {
+		t.Errorf("Create should return ErrConflict for an opened database, but %v", err)
+	}
+
+	_, err = remote.Set("alice", "1")
+	if err != nil {
+		t.Fatalf("Set should not return error, but %s", err)
+	}
+	_, value, err := remote.Get("alice")
+	if err != nil || string(value) != "1" {
+		t.Errorf("Get should return the value, but %q (%v)", value, err)
+	}
+	_, _, err = remote.Get("missing")
+	if !errors.Is(err, kaleidoscope.ErrNotFound) || status.Code(err) != codes.NotFound {
+		t.Errorf("Get should return ErrNotFound for a missing key, but %v", err)
+	}
+	_, err = remote.Set("", "1")
+	if !errors.Is(err, kaleidoscope.ErrInvalidKey) {
+		t.Errorf("Set should return ErrInvalidKey for an empty key, but %v", err)
+	}
+	_, err = remote.Del("alice")
+	if err != nil {
+		t.Errorf("Del should not return error, but %s", err)
+	}
+	_, err = remote.Del("alice")
+	if !errors.Is(err, kaleidoscope.ErrNotFound) {
+		t.Errorf("Del should return ErrNotFound for a deleted key, but %v", err)
+	}
+	err = remote.Save()
+	if err != nil {
+		t.Errorf("Save should not return error, but %s", err)
+	}
+}
+
+func TestRemoteWatch(t *testing.T) {
+	remote := testRemote(t)
+	_, err := remote.Create("dbname", 2048)
+	if err != nil {
+		t.Fatalf("Create should not return error, but %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := remote.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch should not return error, but %s", err)
+	}
+
+	// The server subscribes asynchronously, so write until an event arrives.
+	deadline := time.After(5 * time.Second)
+	for received := false; !received; {
+		remote.Set("alice", "1")
+		select {
+		case ev := <-events:
+			if ev.Type != "set" || ev.Key != "alice" {
+				t.Errorf("Watch should send the set event, but %+v", ev)
+			}
+			received = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Watch should send events")
+		}
+	}
+
+	// Leave an event unread, so the forwarder has to give up on sending.
+	remote.Set("bob", "2")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Watch should close the events when the context is done")
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	ci "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/monochromegane/kaleidoscope"
+)
+
+// fakeIPFS is an in-memory daemon with the commands the servers use to
+// create, open, read, write and publish databases.
+type fakeIPFS struct {
+	dir   string
+	nodes map[string]map[string]string
+	data  map[string][]byte
+	keys  map[string]string
+	names map[string]string
+	mu    sync.Mutex
+}
+
+// testServer returns a server backed by a fake daemon under a temporary
+// IPFS_PATH.
+func testServer(t *testing.T) (*server, *fakeIPFS) {
+	dir := t.TempDir()
+	t.Setenv(kaleidoscope.EnvDir, dir)
+	ipfs := &fakeIPFS{
+		dir:   dir,
+		nodes: map[string]map[string]string{kaleidoscope.EmptyDirMultiHash: {}},
+		data:  map[string][]byte{},
+		keys:  map[string]string{},
+		names: map[string]string{},
+	}
+	ts := httptest.NewServer(ipfs)
+	t.Cleanup(ts.Close)
+	err := ioutil.WriteFile(filepath.Join(dir, kaleidoscope.DefaultApiFile), []byte(ts.URL), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.close() })
+	return s, ipfs
+}
+
+func (f *fakeIPFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	args := r.URL.Query()["arg"]
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "id":
+		fmt.Fprintln(w, `{"ID":"QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N"}`)
+	case "key/gen":
+		err = f.keyGen(args[0])
+	case "add":
+		err = f.add(w, r)
+	case "cat":
+		var hash string
+		hash, err = f.resolve(args[0])
+		if err == nil {
+			w.Write(f.data[hash])
+		}
+	case "object/links":
+		var hash string
+		hash, err = f.resolve(args[0])
+		if err == nil {
+			fmt.Fprintf(w, `{"Hash":"%s","Links":[%s]}`, hash, strings.Join(f.links(hash), ","))
+		}
+	case "object/patch/add-link":
+		var hash string
+		hash, err = f.patch(args[0], strings.Split(args[1], "/"), args[2], r.URL.Query().Get("create") == "true")
+		if err == nil {
+			fmt.Fprintf(w, `{"Hash":"%s"}`, hash)
+		}
+	case "object/patch/rm-link":
+		var hash string
+		hash, err = f.patch(args[0], strings.Split(args[1], "/"), "", false)
+		if err == nil {
+			fmt.Fprintf(w, `{"Hash":"%s"}`, hash)
+		}
+	case "name/publish":
+		key := r.URL.Query().Get("key")
+		f.names[f.keys[key]] = args[0]
+		fmt.Fprintf(w, `{"Name":"%s","Value":"%s"}`, f.keys[key], args[0])
+	case "name/resolve":
+		head, ok := f.names[args[0]]
+		if !ok {
+			err = fmt.Errorf("could not resolve name")
+			break
+		}
+		fmt.Fprintf(w, `{"Path":"%s"}`, head)
+	case "pin/add", "pin/rm":
+		fmt.Fprintf(w, `{"Pins":["%s"]}`, args[0])
+	default:
+		err = fmt.Errorf("unsupported command %s", r.URL.Path)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"Message":%q,"Code":0}`, err.Error())
+	}
+}
+
+// keyGen writes the key to the keystore like the daemon does.
+func (f *fakeIPFS) keyGen(name string) error {
+	priv, _, err := ci.GenerateKeyPair(ci.RSA, 2048)
+	if err != nil {
+		return err
+	}
+	data, err := ci.MarshalPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	keystore := filepath.Join(f.dir, kaleidoscope.DefaultKeystoreRoot)
+	err = os.MkdirAll(keystore, 0700)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(keystore, name), data, 0600)
+	if err != nil {
+		return err
+	}
+	id, err := peer.IDFromPublicKey(priv.GetPublic())
+	if err != nil {
+		return err
+	}
+	f.keys[name] = id.Pretty()
+	return nil
+}
+
+func (f *fakeIPFS) add(w http.ResponseWriter, r *http.Request) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	dir := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(part)
+		hash := f.put(nil, data)
+		dir[part.FileName()] = hash
+		fmt.Fprintf(w, `{"Name":"%s","Hash":"%s","Size":"%d"}`+"\n", part.FileName(), hash, len(data))
+	}
+	if r.URL.Query().Get("wrap-with-directory") == "true" {
+		fmt.Fprintf(w, `{"Name":"","Hash":"%s","Size":"0"}`+"\n", f.put(dir, nil))
+	}
+	return nil
+}
+
+func (f *fakeIPFS) put(links map[string]string, data []byte) string {
+	h := sha256.New()
+	h.Write(data)
+	for _, name := range f.sorted(links) {
+		fmt.Fprintf(h, "\x00%s\x00%s", name, links[name])
+	}
+	hash := "Qm" + hex.EncodeToString(h.Sum(nil))[:20]
+	if links == nil {
+		links = map[string]string{}
+	}
+	f.nodes[hash] = links
+	f.data[hash] = data
+	return hash
+}
+
+func (f *fakeIPFS) sorted(links map[string]string) []string {
+	names := make([]string, 0, len(links))
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakeIPFS) links(hash string) []string {
+	links := []string{}
+	for _, name := range f.sorted(f.nodes[hash]) {
+		links = append(links, fmt.Sprintf(`{"Name":%q,"Hash":"%s"}`, name, f.nodes[hash][name]))
+	}
+	return links
+}
+
+func (f *fakeIPFS) resolve(path string) (string, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/ipfs/"), "/"), "/")
+	hash := parts[0]
+	if _, ok := f.nodes[hash]; !ok {
+		return "", fmt.Errorf("merkledag: not found")
+	}
+	for _, name := range parts[1:] {
+		next, ok := f.nodes[hash][name]
+		if !ok {
+			return "", fmt.Errorf("no link named %q under %s", name, hash)
+		}
+		hash = next
+	}
+	return hash, nil
+}
+
+func (f *fakeIPFS) patch(root string, path []string, ref string, create bool) (string, error) {
+	node, ok := f.nodes[root]
+	if !ok {
+		return "", fmt.Errorf("merkledag: not found")
+	}
+	links := map[string]string{}
+	for name, hash := range node {
+		links[name] = hash
+	}
+	name := path[0]
+	if len(path) == 1 {
+		if ref == "" {
+			if _, ok := links[name]; !ok {
+				return "", fmt.Errorf("no link by that name")
+			}
+			delete(links, name)
+		} else {
+			links[name] = ref
+		}
+		return f.put(links, f.data[root]), nil
+	}
+	child, ok := links[name]
+	if !ok {
+		if !create {
+			return "", fmt.Errorf("no link named %q under %s", name, root)
+		}
+		child = kaleidoscope.EmptyDirMultiHash
+	}
+	hash, err := f.patch(child, path[1:], ref, create)
+	if err != nil {
+		return "", err
+	}
+	links[name] = hash
+	return f.put(links, f.data[root]), nil
+}
@@ -1,9 +1,180 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/monochromegane/kaleidoscope"
+	"github.com/monochromegane/kaleidoscope/kaleidoscopepb"
+	"google.golang.org/grpc"
 )
 
+type config struct {
+	addr            string
+	grpcAddr        string
+	respAddr        string
+	databases       string
+	sensitive       string
+	cacheSize       int
+	sync            bool
+	sweep           time.Duration
+	names           kaleidoscope.NameOptions
+	shutdownTimeout time.Duration
+}
+
 func main() {
-	fmt.Printf("Hello, kaleidoscope\n")
+	var c config
+	flag.StringVar(&c.addr, "addr", env("KALEIDOSCOPE_ADDR", "127.0.0.1:8080"), "HTTP listen address")
+	flag.StringVar(&c.grpcAddr, "grpc", env("KALEIDOSCOPE_GRPC_ADDR", ""), "gRPC listen address (disabled if empty)")
+	flag.StringVar(&c.respAddr, "resp", env("KALEIDOSCOPE_RESP_ADDR", ""), "Redis protocol listen address (disabled if empty)")
+	flag.StringVar(&c.databases, "db", env("KALEIDOSCOPE_DBS", ""), "comma separated databases to open")
+	flag.IntVar(&c.cacheSize, "cache", envInt("KALEIDOSCOPE_CACHE_SIZE", 0), "read cache size in bytes (disabled if 0)")
+	flag.StringVar(&c.sensitive, "sensitive", env("KALEIDOSCOPE_SENSITIVE_DBS", ""), "comma separated databases never caching decrypted values")
+	flag.BoolVar(&c.sync, "sync", env("KALEIDOSCOPE_SYNC", "") == "true", "sync databases with peers")
+	flag.DurationVar(&c.sweep, "sweep", envDuration("KALEIDOSCOPE_SWEEP_INTERVAL", kaleidoscope.DefaultSweepInterval), "interval for removing expired keys (disabled if 0)")
+	flag.DurationVar(&c.names.ResolveTimeout, "resolve-timeout", envDuration("KALEIDOSCOPE_RESOLVE_TIMEOUT", 0), "timeout for resolving databases (daemon default if 0)")
+	flag.BoolVar(&c.names.Recursive, "resolve-recursive", env("KALEIDOSCOPE_RESOLVE_RECURSIVE", "") == "true", "resolve IPNS records recursively")
+	flag.BoolVar(&c.names.Cache, "resolve-cache", env("KALEIDOSCOPE_RESOLVE_CACHE", "") == "true", "allow resolving from the daemon name cache")
+	flag.BoolVar(&c.names.Fallback, "resolve-fallback", env("KALEIDOSCOPE_RESOLVE_FALLBACK", "") == "true", "open databases at the local head when resolving times out")
+	flag.DurationVar(&c.names.Lifetime, "ipns-lifetime", envDuration("KALEIDOSCOPE_IPNS_LIFETIME", 0), "lifetime of published records (daemon default if 0)")
+	flag.DurationVar(&c.names.TTL, "ipns-ttl", envDuration("KALEIDOSCOPE_IPNS_TTL", 0), "TTL of published records (daemon default if 0)")
+	flag.BoolVar(&c.names.AllowOffline, "allow-offline", env("KALEIDOSCOPE_ALLOW_OFFLINE", "") == "true", "publish records without connected peers")
+	flag.DurationVar(&c.shutdownTimeout, "shutdown-timeout", 10*time.Second, "graceful shutdown timeout")
+	flag.Parse()
+
+	err := serve(c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func serve(c config) error {
+	s, err := newServer()
+	if err != nil {
+		return err
+	}
+	if c.cacheSize > 0 {
+		s.setCache(kaleidoscope.NewCache(c.cacheSize))
+	}
+	s.sweep = c.sweep
+	s.kes.SetNameOptions(c.names)
+	for _, dbname := range split(c.sensitive) {
+		s.sensitive[dbname] = true
+	}
+	for _, dbname := range split(c.databases) {
+		err := s.open(dbname, c.sync)
+		if err != nil {
+			return fmt.Errorf("%s: %s", dbname, err)
+		}
+	}
+	if len(s.kes.Opened()) == 0 {
+		return fmt.Errorf("No database to serve. Use -db or KALEIDOSCOPE_DBS.")
+	}
+
+	srv := &http.Server{Addr: c.addr, Handler: s}
+	errc := make(chan error, 3)
+	go func() {
+		fmt.Fprintf(os.Stderr, "kaleidoscope listening on %s\n", c.addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	var gsrv *grpc.Server
+	if c.grpcAddr != "" {
+		l, err := net.Listen("tcp", c.grpcAddr)
+		if err != nil {
+			return err
+		}
+		gsrv = grpc.NewServer()
+		kaleidoscopepb.RegisterKaleidoscopeServer(gsrv, grpcServer{s: s, sync: c.sync})
+		go func() {
+			fmt.Fprintf(os.Stderr, "kaleidoscope gRPC listening on %s\n", c.grpcAddr)
+			errc <- gsrv.Serve(l)
+		}()
+	}
+
+	var rsrv *respServer
+	if c.respAddr != "" {
+		l, err := net.Listen("tcp", c.respAddr)
+		if err != nil {
+			return err
+		}
+		rsrv = newRESPServer(s, s.first, c.sync)
+		go func() {
+			fmt.Fprintf(os.Stderr, "kaleidoscope RESP listening on %s\n", c.respAddr)
+			errc <- rsrv.Serve(l)
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		return err
+	case <-sig:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
+	if rsrv != nil {
+		rsrv.Close()
+	}
+	if gsrv != nil {
+		stopped := make(chan struct{})
+		go func() {
+			gsrv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			// Watch streams never finish on their own.
+			gsrv.Stop()
+		}
+	}
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+	return s.close()
+}
+
+func split(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func envInt(name string, value int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
+	}
+	return value
+}
+
+func envDuration(name string, value time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return v
+	}
+	return value
+}
+
+func env(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
 }
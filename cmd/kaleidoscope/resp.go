@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+var errQuit = errors.New("quit")
+
+// Limits of a request, checked before anything is allocated for it. They
+// match the defaults of Redis except for bulk strings, which are bounded by
+// the values a database can hold.
+const (
+	maxMultibulkLen = 1024 * 1024
+	maxBulkLen      = 64 * 1024 * 1024
+)
+
+type respServer struct {
+	s        *server
+	dbname   string
+	sync     bool
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	mu       sync.Mutex
+}
+
+func newRESPServer(s *server, dbname string, sync bool) *respServer {
+	return &respServer{
+		s:      s,
+		dbname: dbname,
+		sync:   sync,
+		conns:  map[net.Conn]struct{}{},
+	}
+}
+
+func (rs *respServer) Serve(l net.Listener) error {
+	rs.mu.Lock()
+	rs.listener = l
+	rs.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		rs.mu.Lock()
+		rs.conns[conn] = struct{}{}
+		rs.mu.Unlock()
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintf(os.Stderr, "resp: panic serving %s: %v\n", conn.RemoteAddr(), r)
+				}
+				rs.mu.Lock()
+				delete(rs.conns, conn)
+				rs.mu.Unlock()
+				conn.Close()
+			}()
+			c := &respConn{
+				rs:     rs,
+				r:      bufio.NewReader(conn),
+				w:      bufio.NewWriter(conn),
+				dbname: rs.dbname,
+			}
+			c.serve()
+		}()
+	}
+}
+
+func (rs *respServer) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for conn := range rs.conns {
+		conn.Close()
+	}
+	if rs.listener == nil {
+		return nil
+	}
+	return rs.listener.Close()
+}
+
+type respConn struct {
+	rs     *respServer
+	r      *bufio.Reader
+	w      *bufio.Writer
+	wmu    sync.Mutex
+	dbname string
+}
+
+func (c *respConn) serve() {
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			if err != io.EOF {
+				c.reply(func() { respError(c.w, "ERR "+err.Error()) })
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		err = c.dispatch(args)
+		if err == errQuit {
+			return
+		}
+		if err != nil {
+			c.reply(func() { respError(c.w, "ERR "+err.Error()) })
+		}
+	}
+}
+
+func (c *respConn) reply(fn func()) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	fn()
+	c.w.Flush()
+}
+
+func (c *respConn) database() (*kaleidoscope.DB, error) {
+	if c.dbname == "" {
+		return nil, errors.New("no database selected, use SELECT db")
+	}
+	kes, ok := c.rs.s.database(c.dbname)
+	if !ok {
+		return nil, fmt.Errorf("unknown database: %s", c.dbname)
+	}
+	return kes, nil
+}
+
+func (c *respConn) dispatch(args []string) error {
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+	switch name {
+	case "PING":
+		if len(args) > 0 {
+			c.reply(func() { respBulk(c.w, args[0]) })
+		} else {
+			c.reply(func() { respSimple(c.w, "PONG") })
+		}
+		return nil
+	case "ECHO":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		c.reply(func() { respBulk(c.w, args[0]) })
+		return nil
+	case "QUIT":
+		c.reply(func() { respSimple(c.w, "OK") })
+		return errQuit
+	case "COMMAND":
+		// redis-cli asks for command docs on connect.
+		c.reply(func() { respArray(c.w, 0) })
+		return nil
+	case "SELECT":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		if _, ok := c.rs.s.database(args[0]); !ok {
+			err := c.rs.s.open(args[0], c.rs.sync)
+			if err != nil {
+				return err
+			}
+		}
+		c.dbname = args[0]
+		c.reply(func() { respSimple(c.w, "OK") })
+		return nil
+	case "SUBSCRIBE":
+		if len(args) == 0 {
+			return arityError(name)
+		}
+		return c.subscribe(args)
+	}
+
+	kes, err := c.database()
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "GET":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		_, value, err := kes.Get(args[0])
+		if missing(err) {
+			c.reply(func() { respNull(c.w) })
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.reply(func() { respBulk(c.w, string(value)) })
+	case "SET":
+		if len(args) != 2 && len(args) != 4 {
+			return errors.New("syntax error")
+		}
+		var err error
+		if len(args) == 4 {
+			var ttl time.Duration
+			ttl, err = expiry(args[2], args[3])
+			if err != nil {
+				return err
+			}
+			_, err = kes.SetWithTTL(args[0], args[1], ttl)
+		} else {
+			_, err = kes.Set(args[0], args[1])
+		}
+		if err != nil {
+			return err
+		}
+		c.reply(func() { respSimple(c.w, "OK") })
+	case "DEL":
+		if len(args) == 0 {
+			return arityError(name)
+		}
+		n := 0
+		for _, key := range args {
+			_, err := kes.Del(key)
+			if missing(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		c.reply(func() { respInteger(c.w, n) })
+	case "EXISTS":
+		if len(args) == 0 {
+			return arityError(name)
+		}
+		n := 0
+		for _, key := range args {
+			_, err := kes.Hash(key)
+			if missing(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		c.reply(func() { respInteger(c.w, n) })
+	case "KEYS":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		keys, err := kes.Keys()
+		if err != nil {
+			return err
+		}
+		var matched []string
+		for _, key := range keys {
+			if kaleidoscope.MatchGlob(args[0], key) {
+				matched = append(matched, key)
+			}
+		}
+		c.reply(func() {
+			respArray(c.w, len(matched))
+			for _, key := range matched {
+				respBulk(c.w, key)
+			}
+		})
+	case "SAVE":
+		err := kes.Save()
+		if err != nil {
+			return err
+		}
+		c.reply(func() { respSimple(c.w, "OK") })
+	default:
+		return fmt.Errorf("unknown command '%s'", strings.ToLower(name))
+	}
+	return nil
+}
+
+func (c *respConn) subscribe(channels []string) error {
+	type subscription struct {
+		channel string
+		events  <-chan kaleidoscope.Event
+		cancel  func()
+	}
+	var subs []subscription
+	defer func() {
+		for _, sub := range subs {
+			sub.cancel()
+		}
+	}()
+	for _, ch := range channels {
+		kes, ok := c.rs.s.database(ch)
+		if !ok {
+			return fmt.Errorf("unknown database: %s", ch)
+		}
+		events, cancel := kes.Watch()
+		subs = append(subs, subscription{channel: ch, events: events, cancel: cancel})
+		n := len(subs)
+		c.reply(func() {
+			respArray(c.w, 3)
+			respBulk(c.w, "subscribe")
+			respBulk(c.w, ch)
+			respInteger(c.w, n)
+		})
+	}
+
+	// Only UNSUBSCRIBE, PING and QUIT are allowed while subscribed.
+	done := make(chan error, 1)
+	go func() {
+		for {
+			args, err := readCommand(c.r)
+			if err != nil {
+				done <- errQuit
+				return
+			}
+			if len(args) == 0 {
+				continue
+			}
+			switch strings.ToUpper(args[0]) {
+			case "UNSUBSCRIBE":
+				c.reply(func() {
+					respArray(c.w, 3)
+					respBulk(c.w, "unsubscribe")
+					respNull(c.w)
+					respInteger(c.w, 0)
+				})
+				done <- nil
+				return
+			case "PING":
+				c.reply(func() {
+					respArray(c.w, 2)
+					respBulk(c.w, "pong")
+					respBulk(c.w, "")
+				})
+			case "QUIT":
+				c.reply(func() { respSimple(c.w, "OK") })
+				done <- errQuit
+				return
+			default:
+				c.reply(func() { respError(c.w, "ERR only UNSUBSCRIBE, PING and QUIT are allowed in this context") })
+			}
+		}
+	}()
+
+	merged := make(chan kaleidoscope.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	for _, sub := range subs {
+		go func(events <-chan kaleidoscope.Event) {
+			for ev := range events {
+				select {
+				case merged <- ev:
+				case <-stop:
+					return
+				}
+			}
+		}(sub.events)
+	}
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case ev := <-merged:
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			c.reply(func() {
+				respArray(c.w, 3)
+				respBulk(c.w, "message")
+				respBulk(c.w, ev.Database)
+				respBulk(c.w, string(payload))
+			})
+		}
+	}
+}
+
+// missing reports whether err means that the key does not exist. Keys the
+// database can not hold never exist.
+func missing(err error) bool {
+	return errors.Is(err, kaleidoscope.ErrNotFound) || errors.Is(err, kaleidoscope.ErrInvalidKey)
+}
+
+func expiry(unit, n string) (time.Duration, error) {
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, errors.New("invalid expire time in 'set' command")
+	}
+	switch strings.ToUpper(unit) {
+	case "EX":
+		return time.Duration(v) * time.Second, nil
+	case "PX":
+		return time.Duration(v) * time.Millisecond, nil
+	}
+	return 0, errors.New("syntax error")
+}
+
+func arityError(name string) error {
+	return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > maxMultibulkLen {
+		return nil, errors.New("Protocol error: invalid multibulk length")
+	}
+	if n <= 0 {
+		// *-1 is a null array and *0 an empty one, both are ignored.
+		return nil, nil
+	}
+	// The header alone does not prove that n arguments follow.
+	capacity := n
+	if capacity > 16 {
+		capacity = 16
+	}
+	args := make([]string, 0, capacity)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("Protocol error: expected '$', got '%s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, errors.New("Protocol error: invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func respSimple(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func respError(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "-%s\r\n", strings.Replace(s, "\n", " ", -1))
+}
+
+func respInteger(w *bufio.Writer, n int) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func respBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func respNull(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func respArray(w *bufio.Writer, n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+func TestReadCommand(t *testing.T) {
+	cases := []struct {
+		input string
+		args  []string
+		err   string
+	}{
+		{"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", []string{"GET", "key"}, ""},
+		{"PING hello\r\n", []string{"PING", "hello"}, ""},
+		{"*-1\r\n", nil, ""},
+		{"*0\r\n", nil, ""},
+		{"*-2\r\n", nil, "invalid multibulk length"},
+		{fmt.Sprintf("*%d\r\n", maxMultibulkLen+1), nil, "invalid multibulk length"},
+		{"*1\r\n$-1\r\n", nil, "invalid bulk length"},
+		{fmt.Sprintf("*1\r\n$%d\r\n", maxBulkLen+1), nil, "invalid bulk length"},
+		{"*1\r\n+GET\r\n", nil, "expected '$'"},
+	}
+	for _, c := range cases {
+		args, err := readCommand(bufio.NewReader(strings.NewReader(c.input)))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("readCommand(%q) should return %q, but %v", c.input, c.err, err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(args, " ") != strings.Join(c.args, " ") {
+			t.Errorf("readCommand(%q) should return %v, but %v (%v)", c.input, c.args, args, err)
+		}
+	}
+}
+
+func TestRESPServerRejectsMalformedHeaders(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := newRESPServer(&server{}, "", false)
+	go rs.Serve(l)
+	defer rs.Close()
+
+	send := func(input string) string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, input)
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	if reply := send("*-1\r\n*1\r\n$4\r\nPING\r\n"); reply != "+PONG" {
+		t.Errorf("Server should ignore a null array, but %q", reply)
+	}
+	if reply := send("*2147483647\r\n"); !strings.HasPrefix(reply, "-ERR Protocol error") {
+		t.Errorf("Server should reject an oversized multibulk length, but %q", reply)
+	}
+	if reply := send("*1\r\n$2147483647\r\n"); !strings.HasPrefix(reply, "-ERR Protocol error") {
+		t.Errorf("Server should reject an oversized bulk length, but %q", reply)
+	}
+	if reply := send("PING\r\n"); reply != "+PONG" {
+		t.Errorf("Server should keep serving after malformed requests, but %q", reply)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	cases := []struct {
+		err     error
+		missing bool
+	}{
+		{nil, false},
+		{&kaleidoscope.NotFoundError{Key: "key", Root: "QmRoot"}, true},
+		{&kaleidoscope.Error{Command: "object/patch/rm-link", Message: "no link by that name", Err: kaleidoscope.ErrNotFound}, true},
+		{&kaleidoscope.KeyError{Key: "", Reason: "must not be empty"}, true},
+		{fmt.Errorf("%w: connection refused", kaleidoscope.ErrDaemonUnavailable), false},
+	}
+	for _, c := range cases {
+		if missing(c.err) != c.missing {
+			t.Errorf("missing(%v) should be %v", c.err, c.missing)
+		}
+	}
+}
+
+// readReply reads one reply and renders it the way redis-cli would show it
+// on one line: arrays are joined with ',' and a null bulk is "(nil)".
+func readReply(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading reply: %s", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		if line == "$-1" {
+			return "(nil)"
+		}
+		value, _ := r.ReadString('\n')
+		return strings.TrimSuffix(value, "\r\n")
+	case '*':
+		var n int
+		fmt.Sscanf(line[1:], "%d", &n)
+		items := make([]string, n)
+		for i := range items {
+			items[i] = readReply(t, r)
+		}
+		return strings.Join(items, ",")
+	}
+	return line
+}
+
+func TestRESPServerDispatch(t *testing.T) {
+	s, _ := testServer(t)
+	_, err := s.create("dbname", 2048, false)
+	if err != nil {
+		t.Fatalf("create should not return error, but %s", err)
+	}
+	// Close it so SELECT has to open and resolve the database.
+	s.kes.Close("dbname")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := newRESPServer(s, "", false)
+	go rs.Serve(l)
+	defer rs.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	cases := []struct {
+		args  []string
+		reply string
+	}{
+		{[]string{"GET", "alice"}, "-ERR no database selected, use SELECT db"},
+		{[]string{"SELECT", "unknown"}, "-ERR"},
+		{[]string{"SELECT", "dbname"}, "+OK"},
+		{[]string{"GET", "alice"}, "(nil)"},
+		{[]string{"SET", "alice", "1"}, "+OK"},
+		{[]string{"SET", "users/bob", "2"}, "+OK"},
+		{[]string{"SET", "users/carol", "3"}, "+OK"},
+		{[]string{"GET", "alice"}, "1"},
+		{[]string{"GET", "users/bob"}, "2"},
+		{[]string{"SET", "alice"}, "-ERR syntax error"},
+		{[]string{"KEYS", "*"}, "__database_name,alice,users/bob,users/carol"},
+		{[]string{"KEYS", "users*"}, "users/bob,users/carol"},
+		{[]string{"KEYS", "*/c?rol"}, "users/carol"},
+		{[]string{"DEL", "users/bob", "missing"}, ":1"},
+		{[]string{"EXISTS", "alice", "users/bob"}, ":1"},
+		{[]string{"FLUSHALL"}, "-ERR unknown command 'flushall'"},
+	}
+	for _, c := range cases {
+		fmt.Fprintf(conn, "*%d\r\n", len(c.args))
+		for _, arg := range c.args {
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+		reply := readReply(t, r)
+		if !strings.HasPrefix(reply, c.reply) {
+			t.Errorf("%v should reply %q, but %q", c.args, c.reply, reply)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+const apiPrefix = "/v1/"
+
+// maxValueLen limits the body of a PUT, like maxBulkLen limits SET.
+var maxValueLen int64 = maxBulkLen
+
+type server struct {
+	kes       *kaleidoscope.Kaleidoscope
+	cache     *kaleidoscope.Cache
+	sensitive map[string]bool
+	sweep     time.Duration
+	first     string
+	mu        sync.Mutex
+}
+
+func newServer() (*server, error) {
+	kes, err := kaleidoscope.New()
+	if err != nil {
+		return nil, err
+	}
+	return &server{kes: kes, sensitive: map[string]bool{}}, nil
+}
+
+func (s *server) setCache(c *kaleidoscope.Cache) {
+	s.cache = c
+	s.kes.SetCache(c)
+}
+
+func (s *server) open(dbname string, sync bool) error {
+	_, opened := s.kes.Lookup(dbname)
+	db, err := s.kes.Open(dbname)
+	if err != nil {
+		return err
+	}
+	if r := db.Recovery(); !opened {
+		if r.Local() {
+			fmt.Fprintf(os.Stderr, "warning: resolving %s timed out, opened at the local head (%s)\n", dbname, r.Head)
+		}
+		if r.Ahead() {
+			fmt.Fprintf(os.Stderr, "warning: local head of %s (%s) is ahead of the published record (%s), %d operation(s) recovered\n",
+				dbname, r.Head, r.Published, r.Replayed)
+		}
+	}
+	db.SetPlaintextCache(!s.sensitive[dbname])
+	if s.sweep > 0 {
+		db.StartSweeper(s.sweep)
+	}
+	if sync {
+		err = db.StartSync()
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first == "" {
+		s.first = dbname
+	}
+	return nil
+}
+
+func (s *server) create(dbname string, size int, sync bool) (string, error) {
+	db, err := s.kes.CreateDB(dbname, size)
+	if err != nil {
+		return "", err
+	}
+	db.SetPlaintextCache(!s.sensitive[dbname])
+	err = db.Save()
+	if err != nil {
+		return "", err
+	}
+	if s.sweep > 0 {
+		db.StartSweeper(s.sweep)
+	}
+	if sync {
+		err = db.StartSync()
+		if err != nil {
+			return "", err
+		}
+	}
+	return db.Head(), nil
+}
+
+func (s *server) close() error {
+	var first error
+	for _, dbname := range s.kes.Opened() {
+		db, ok := s.kes.Lookup(dbname)
+		if !ok {
+			continue
+		}
+		db.StopSync()
+		if err := db.Save(); err != nil && first == nil {
+			first = err
+		}
+		s.kes.Close(dbname)
+	}
+	return first
+}
+
+func (s *server) database(dbname string) (*kaleidoscope.DB, bool) {
+	return s.kes.Lookup(dbname)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if r.URL.Path == "/stats" {
+		stats := map[string]interface{}{"databases": s.kes.Opened()}
+		if s.cache != nil {
+			stats["cache"] = s.cache.Stats()
+		}
+		writeJSON(w, http.StatusOK, stats)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	// /v1/{db}/keys[/{key}], /v1/{db}/save, /v1/{db}/sync
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), apiPrefix), "/", 3)
+	if len(parts) < 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	dbname, err := url.PathUnescape(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	kes, ok := s.database(dbname)
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown database: "+dbname)
+		return
+	}
+
+	switch {
+	case parts[1] == "keys" && len(parts) == 2:
+		s.list(w, r, kes)
+	case parts[1] == "keys" && len(parts) == 3:
+		key, err := url.PathUnescape(parts[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.key(w, r, kes, key)
+	case parts[1] == "save" && len(parts) == 2:
+		s.save(w, r, kes)
+	case parts[1] == "sync" && len(parts) == 2:
+		s.sync(w, r, kes)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *server) list(w http.ResponseWriter, r *http.Request, kes *kaleidoscope.DB) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	keys, err := kes.Keys()
+	if err != nil {
+		writeError(w, httpStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+func (s *server) key(w http.ResponseWriter, r *http.Request, kes *kaleidoscope.DB, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		v, err := kes.Value(key)
+		if err != nil {
+			writeError(w, httpStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, kaleidoscope.Record{Key: key, Value: string(v.Data), Timestamp: v.Timestamp, Expires: v.Expires, Codec: v.Codec})
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxValueLen))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("value must not exceed %d bytes", maxValueLen))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var ttl time.Duration
+		if v := r.URL.Query().Get("ttl"); v != "" {
+			ttl, err = time.ParseDuration(v)
+			if err != nil || ttl <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid ttl: "+v)
+				return
+			}
+		}
+		var hash string
+		if ttl > 0 {
+			hash, err = kes.SetWithTTL(key, string(value), ttl)
+		} else {
+			hash, err = kes.Set(key, string(value))
+		}
+		if err != nil {
+			writeError(w, httpStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"key": key, "hash": hash})
+	case http.MethodDelete:
+		hash, err := kes.Del(key)
+		if err != nil {
+			writeError(w, httpStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"key": key, "hash": hash})
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (s *server) save(w http.ResponseWriter, r *http.Request, kes *kaleidoscope.DB) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	err := kes.Save()
+	if err != nil {
+		writeError(w, httpStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"database": kes.Name(), "head": kes.Head()})
+}
+
+func (s *server) sync(w http.ResponseWriter, r *http.Request, kes *kaleidoscope.DB) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"database": kes.Name(),
+		"head":     kes.Head(),
+		"syncing":  kes.Syncing(),
+	})
+}
+
+// httpStatus maps the errors of a database to the status of the response.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, kaleidoscope.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, kaleidoscope.ErrInvalidKey):
+		return http.StatusBadRequest
+	case errors.Is(err, kaleidoscope.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, kaleidoscope.ErrDaemonUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/monochromegane/kaleidoscope"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{&kaleidoscope.NotFoundError{Key: "key", Root: "QmRoot"}, http.StatusNotFound},
+		{&kaleidoscope.KeyError{Key: "", Reason: "must not be empty"}, http.StatusBadRequest},
+		{fmt.Errorf("Key %s was modified: %w", "key", kaleidoscope.ErrConflict), http.StatusConflict},
+		{fmt.Errorf("%w: connection refused", kaleidoscope.ErrDaemonUnavailable), http.StatusServiceUnavailable},
+		{kaleidoscope.ErrDecrypt, http.StatusInternalServerError},
+		{errors.New("some failure"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if status := httpStatus(c.err); status != c.status {
+			t.Errorf("httpStatus(%v) should be %d, but %d", c.err, c.status, status)
+		}
+	}
+}
+
+func TestServerServeHTTP(t *testing.T) {
+	s, _ := testServer(t)
+	_, err := s.create("dbname", 2048, false)
+	if err != nil {
+		t.Fatalf("create should not return error, but %s", err)
+	}
+	defer func(n int64) { maxValueLen = n }(maxValueLen)
+	maxValueLen = 16
+
+	cases := []struct {
+		method string
+		target string
+		body   string
+		status int
+		expect string
+	}{
+		{"GET", "/healthz", "", http.StatusOK, `"ok"`},
+		{"GET", "/v2/dbname/keys", "", http.StatusNotFound, "not found"},
+		{"GET", "/v1/dbname", "", http.StatusNotFound, "not found"},
+		{"GET", "/v1/unknown/keys", "", http.StatusNotFound, "unknown database: unknown"},
+		{"GET", "/v1/dbname/other", "", http.StatusNotFound, "not found"},
+		{"PUT", "/v1/dbname/keys/alice", "1", http.StatusOK, `"key":"alice"`},
+		{"PUT", "/v1/dbname/keys/users%2Fbob", "2", http.StatusOK, `"key":"users/bob"`},
+		{"GET", "/v1/dbname/keys/users%2Fbob", "", http.StatusOK, `"value":"2"`},
+		{"GET", "/v1/dbname/keys/users/bob", "", http.StatusOK, `"key":"users/bob"`},
+		{"GET", "/v1/dbname/keys", "", http.StatusOK, `"alice","users/bob"`},
+		{"PUT", "/v1/dbname/keys/carol?ttl=1h", "3", http.StatusOK, `"key":"carol"`},
+		{"GET", "/v1/dbname/keys/carol", "", http.StatusOK, `"expires":`},
+		{"PUT", "/v1/dbname/keys/carol?ttl=soon", "3", http.StatusBadRequest, "invalid ttl: soon"},
+		{"PUT", "/v1/dbname/keys/carol?ttl=-1s", "3", http.StatusBadRequest, "invalid ttl: -1s"},
+		{"PUT", "/v1/dbname/keys/carol", strings.Repeat("x", 17), http.StatusRequestEntityTooLarge, "must not exceed 16 bytes"},
+		{"PUT", "/v1/dbname/keys/", "1", http.StatusBadRequest, ""},
+		{"POST", "/v1/dbname/keys/alice", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"POST", "/v1/dbname/keys", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"DELETE", "/v1/dbname/keys/alice", "", http.StatusOK, `"key":"alice"`},
+		{"GET", "/v1/dbname/keys/alice", "", http.StatusNotFound, ""},
+		{"GET", "/v1/dbname/save", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"POST", "/v1/dbname/save", "", http.StatusOK, `"database":"dbname"`},
+		{"GET", "/v1/dbname/sync", "", http.StatusOK, `"syncing":false`},
+		{"PUT", "/v1/dbname/sync", "", http.StatusMethodNotAllowed, "method not allowed"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(c.method, c.target, strings.NewReader(c.body)))
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.expect) {
+			t.Errorf("%s %s should respond %d with %s, but %d %s", c.method, c.target, c.status, c.expect, w.Code, w.Body)
+		}
+		if w.Code == http.StatusMethodNotAllowed && w.Header().Get("Allow") == "" {
+			t.Errorf("%s %s should set Allow", c.method, c.target)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package kaleidoscope
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	RawCodec    Codec = rawCodec{}
+	StringCodec Codec = stringCodec{}
+	JSONCodec   Codec = jsonCodec{}
+	GobCodec    Codec = gobCodec{}
+	ProtoCodec  Codec = protoCodec{}
+)
+
+var (
+	codecs = map[string]Codec{}
+	codecm sync.RWMutex
+)
+
+func init() {
+	for _, c := range []Codec{RawCodec, StringCodec, JSONCodec, GobCodec, ProtoCodec} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec makes c available to read values written with it. The name
+// is stored in the value metadata, so it can not contain ';' or ','.
+func RegisterCodec(c Codec) error {
+	name := c.Name()
+	if name == "" || strings.ContainsAny(name, ";,") {
+		return fmt.Errorf("Invalid codec name: %q", name)
+	}
+	codecm.Lock()
+	defer codecm.Unlock()
+	codecs[name] = c
+	return nil
+}
+
+func LookupCodec(name string) (Codec, bool) {
+	if name == "" {
+		// Values written without a codec are plain strings.
+		name = StringCodec.Name()
+	}
+	codecm.RLock()
+	defer codecm.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+func Codecs() []string {
+	codecm.RLock()
+	defer codecm.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Value struct {
+	Timestamp string
+	Expires   int64
+	Codec     string
+	Data      []byte
+}
+
+func (v Value) Decode(out interface{}) error {
+	c, ok := LookupCodec(v.Codec)
+	if !ok {
+		return fmt.Errorf("Unknown codec: %s", v.Codec)
+	}
+	return c.Unmarshal(v.Data, out)
+}
+
+func (d *DB) Value(key string) (Value, error) {
+	m, data, err := d.value(d.Head(), key)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Timestamp: string(m.timestamp), Expires: m.expires, Codec: m.codec, Data: data}, nil
+}
+
+func (d *DB) SetValue(key string, v interface{}, c Codec) (string, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err = d.purge(key)
+	if err != nil {
+		return "", err
+	}
+	hash, err := d.addWithMetadata(string(data), metadata{codec: c.Name()})
+	if err != nil {
+		return "", err
+	}
+	return d.setHash(key, hash, true)
+}
+
+func SetAs[T any](d *DB, key string, c Codec, v T) (string, error) {
+	return d.SetValue(key, v, c)
+}
+
+func GetAs[T any](d *DB, key string) (T, error) {
+	var t T
+	v, err := d.Value(key)
+	if err != nil {
+		return t, err
+	}
+	return decode[T](v)
+}
+
+func SetJSON[T any](d *DB, key string, v T) (string, error) {
+	return d.SetValue(key, v, JSONCodec)
+}
+
+func GetJSON[T any](d *DB, key string) (T, error) {
+	var t T
+	v, err := d.Value(key)
+	if err != nil {
+		return t, err
+	}
+	if v.Codec != "" && v.Codec != JSONCodec.Name() {
+		return t, fmt.Errorf("%s is encoded with %s, not json", key, v.Codec)
+	}
+	v.Codec = JSONCodec.Name()
+	return decode[T](v)
+}
+
+func decode[T any](v Value) (T, error) {
+	var t T
+	typ := reflect.TypeOf(t)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		// Pointer types such as generated protobuf messages are decoded into
+		// a freshly allocated value rather than into a pointer to nil.
+		t = reflect.New(typ.Elem()).Interface().(T)
+		err := v.Decode(t)
+		return t, err
+	}
+	err := v.Decode(&t)
+	return t, err
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case *[]byte:
+		return *b, nil
+	}
+	return nil, fmt.Errorf("raw codec cannot marshal %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec cannot unmarshal into %T", v)
+	}
+	*b = append([]byte{}, data...)
+	return nil
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Name() string {
+	return "string"
+}
+
+func (stringCodec) Marshal(v interface{}) ([]byte, error) {
+	switch s := v.(type) {
+	case string:
+		return []byte(s), nil
+	case *string:
+		return []byte(*s), nil
+	case []byte:
+		return s, nil
+	}
+	return nil, fmt.Errorf("string codec cannot marshal %T", v)
+}
+
+func (stringCodec) Unmarshal(data []byte, v interface{}) error {
+	switch s := v.(type) {
+	case *string:
+		*s = string(data)
+		return nil
+	case *[]byte:
+		*s = append([]byte{}, data...)
+		return nil
+	}
+	return fmt.Errorf("string codec cannot unmarshal into %T", v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("proto codec cannot marshal %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("proto codec cannot unmarshal into %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,101 @@
+package kaleidoscope
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testDocument struct {
+	Name string
+	Tags []string
+}
+
+func testCodecIPFS() *httptest.Server {
+	var stored []byte
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/id":
+			fmt.Fprintln(w, `{"ID":"QmTestPeer"}`)
+		case "/api/v0/add":
+			f, _, _ := r.FormFile("file")
+			stored, _ = ioutil.ReadAll(f)
+			fmt.Fprintln(w, `{"Name":"","Hash":"QmValue","Size":"67"}`)
+		case "/api/v0/object/patch/add-link":
+			fmt.Fprintln(w, `{"Hash":"QmRootAfter"}`)
+		case "/api/v0/cat":
+			w.Write(stored)
+		}
+	}))
+}
+
+func TestDBSetJSONAndGetJSON(t *testing.T) {
+	ipfs := testCodecIPFS()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	doc := testDocument{Name: "some", Tags: []string{"a", "b"}}
+	_, err := SetJSON(db, "doc", doc)
+	if err != nil {
+		t.Errorf("SetJSON should not return error, but %s", err)
+	}
+
+	v, err := db.Value("doc")
+	if err != nil || v.Codec != "json" {
+		t.Errorf("Value should record json codec, but %+v %v", v, err)
+	}
+	got, err := GetJSON[testDocument](db, "doc")
+	if err != nil {
+		t.Errorf("GetJSON should not return error, but %s", err)
+	}
+	if got.Name != doc.Name || len(got.Tags) != 2 {
+		t.Errorf("GetJSON should round-trip %v, but %v", doc, got)
+	}
+}
+
+func TestDBGetJSONRejectsOtherCodec(t *testing.T) {
+	ipfs := testCodecIPFS()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	doc := testDocument{Name: "some"}
+	_, err := SetAs(db, "doc", GobCodec, doc)
+	if err != nil {
+		t.Errorf("SetAs should not return error, but %s", err)
+	}
+	got, err := GetAs[testDocument](db, "doc")
+	if err != nil || got.Name != "some" {
+		t.Errorf("GetAs should decode with the recorded codec, but %v %v", got, err)
+	}
+	_, err = GetJSON[testDocument](db, "doc")
+	if err == nil {
+		t.Errorf("GetJSON should reject a gob encoded value")
+	}
+}
+
+type namedCodec struct {
+	Codec
+	name string
+}
+
+func (c namedCodec) Name() string {
+	return c.name
+}
+
+func TestRegisterCodecInvalidName(t *testing.T) {
+	for _, name := range []string{"", "a;b", "a,b"} {
+		err := RegisterCodec(namedCodec{Codec: RawCodec, name: name})
+		if err == nil {
+			t.Errorf("RegisterCodec should reject %q", name)
+		}
+		if _, ok := LookupCodec(name); name != "" && ok {
+			t.Errorf("RegisterCodec should not register %q", name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package kaleidoscope
+
+const collectionRoot = "__collections"
+
+// Collection is a namespace of keys stored in its own directory under the
+// database root. It is synchronized over its own pubsub topic.
+type Collection struct {
+	db   *DB
+	name string
+}
+
+func (d *DB) Collection(name string) *Collection {
+	return &Collection{db: d, name: name}
+}
+
+func (d *DB) Collections() ([]string, error) {
+	hash, ok, err := d.walk(d.Head(), collectionRoot)
+	if err != nil || !ok {
+		return []string{}, err
+	}
+	links, err := d.links(hash)
+	if err != nil {
+		return []string{}, err
+	}
+	names := make([]string, 0, len(links))
+	for _, link := range links {
+		names = append(names, unescapeKey(link.Name))
+	}
+	return names, nil
+}
+
+func (c *Collection) Name() string {
+	return c.name
+}
+
+func (c *Collection) Set(key, value string) (string, error) {
+	err := c.validate()
+	if err == nil {
+		err = checkKey(key)
+	}
+	if err != nil {
+		return "", err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	hash, err := c.db.add(value)
+	if err != nil {
+		return "", err
+	}
+	return c.db.apply(Operation{Type: "set", Collection: c.name, Key: key, Hash: hash}, true)
+}
+
+func (c *Collection) Get(key string) ([]byte, []byte, error) {
+	err := c.validate()
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+	root, ok, err := c.db.walk(c.db.Head(), collectionRoot, c.name)
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+	if !ok {
+		return []byte{}, []byte{}, &NotFoundError{Key: key, Root: collectionPath(c.name, "")}
+	}
+	return c.db.get(root, key)
+}
+
+func (c *Collection) Del(key string) (string, error) {
+	err := c.validate()
+	if err != nil {
+		return "", err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return c.db.apply(Operation{Type: "del", Collection: c.name, Key: key}, true)
+}
+
+func (c *Collection) Keys() ([]string, error) {
+	err := c.validate()
+	if err != nil {
+		return []string{}, err
+	}
+	root, ok, err := c.db.walk(c.db.Head(), collectionRoot, c.name)
+	if err != nil || !ok {
+		return []string{}, err
+	}
+	links, err := c.db.links(root)
+	if err != nil {
+		return []string{}, err
+	}
+	keys := make([]string, 0, len(links))
+	for _, link := range links {
+		if c.db.expiredHash(link.Hash) {
+			continue
+		}
+		keys = append(keys, unescapeKey(link.Name))
+	}
+	return keys, nil
+}
+
+func (c *Collection) StartSync() error {
+	err := c.validate()
+	if err != nil {
+		return err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return c.db.startSync(c.name)
+}
+
+func (c *Collection) StopSync() {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if stream, ok := c.db.streams[c.name]; ok {
+		stream.Close()
+		delete(c.db.streams, c.name)
+	}
+}
+
+func (c *Collection) Syncing() bool {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return c.db.streams[c.name].IsRunning()
+}
+
+func (c *Collection) validate() error {
+	return checkKey(c.name)
+}
+
+func collectionPath(collection, key string) string {
+	return collectionRoot + "/" + escapeKey(collection) + "/" + escapeKey(key)
+}
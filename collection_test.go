@@ -0,0 +1,173 @@
+package kaleidoscope
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollectionSetGetDel(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.Set("alice", "root value")
+	users := db.Collection("users")
+	_, err := users.Set("alice", "user value")
+	if err != nil {
+		t.Errorf("Set should not return error, but %s", err)
+	}
+	db.Collection("groups").Set("admins", "alice")
+
+	_, value, err := users.Get("alice")
+	if err != nil || string(value) != "user value" {
+		t.Errorf("Get should return the collection value, but %s %v", value, err)
+	}
+	_, value, _ = db.Get("alice")
+	if string(value) != "root value" {
+		t.Errorf("Get should not see collection values, but %s", value)
+	}
+
+	keys, _ := db.Keys()
+	if strings.Join(keys, ",") != "alice" {
+		t.Errorf("Keys should not list collections, but %v", keys)
+	}
+	keys, _ = users.Keys()
+	if strings.Join(keys, ",") != "alice" {
+		t.Errorf("Collection keys should be listed independently, but %v", keys)
+	}
+	names, _ := db.Collections()
+	if strings.Join(names, ",") != "groups,users" {
+		t.Errorf("Collections should list collection names, but %v", names)
+	}
+
+	_, err = users.Del("alice")
+	if err != nil {
+		t.Errorf("Del should not return error, but %s", err)
+	}
+	if _, _, err := users.Get("alice"); err == nil {
+		t.Errorf("Get should return error for a deleted key")
+	}
+	if _, value, _ := db.Get("alice"); string(value) != "root value" {
+		t.Errorf("Del should not touch the root key, but %s", value)
+	}
+	if keys, _ := db.Collection("missing").Keys(); len(keys) != 0 {
+		t.Errorf("Keys of a missing collection should be empty, but %v", keys)
+	}
+}
+
+func TestCollectionValidatesBeforeUpload(t *testing.T) {
+	requests := 0
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+
+	for _, c := range []struct{ collection, key string }{{"users", ""}, {"", "alice"}} {
+		_, err := db.Collection(c.collection).Set(c.key, "v")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Set(%q, %q) should return ErrInvalidKey, but %v", c.collection, c.key, err)
+		}
+	}
+	if requests != 0 || len(db.state.Objects) != 0 {
+		t.Errorf("Set should not upload values with invalid names, but %d requests, %v", requests, db.state.Objects)
+	}
+}
+
+func TestCollectionSlashInNames(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.Set("users/alice", "root value")
+	db.Collection("a/b").Set("c/d", "collection value")
+
+	if keys, _ := db.Keys(); strings.Join(keys, ",") != "users/alice" {
+		t.Errorf("Keys should not split keys containing /, but %v", keys)
+	}
+	if names, _ := db.Collections(); strings.Join(names, ",") != "a/b" {
+		t.Errorf("Collections should not split names containing /, but %v", names)
+	}
+	_, value, err := db.Collection("a/b").Get("c/d")
+	if err != nil || string(value) != "collection value" {
+		t.Errorf("Get should return the collection value, but %s %v", value, err)
+	}
+	if _, _, err := db.Collection("a").Get("b/c/d"); err == nil {
+		t.Errorf("Get should not resolve a collection key through another collection")
+	}
+}
+
+func TestCollectionSync(t *testing.T) {
+	dag, unused := newTestDAG()
+	unused.Close()
+	var mu sync.Mutex
+	published := []string{}
+	messages := make(chan string, 1)
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("arg")
+		switch r.URL.Path {
+		case "/api/v0/pubsub/pub":
+			mu.Lock()
+			published = append(published, topic)
+			mu.Unlock()
+		case "/api/v0/pubsub/sub":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			if topic == "dbname/users" {
+				fmt.Fprintf(w, `{"Data":"%s"}`+"\n", base64.StdEncoding.EncodeToString([]byte(<-messages)))
+				w.(http.Flusher).Flush()
+			}
+			<-r.Context().Done()
+		default:
+			dag.ServeHTTP(w, r)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	users := db.Collection("users")
+	hash, _ := db.add("remote value")
+
+	err := users.StartSync()
+	if err != nil {
+		t.Fatalf("StartSync should not return error, but %s", err)
+	}
+	defer db.StopSync()
+	if !users.Syncing() || db.Syncing() {
+		t.Errorf("StartSync should only sync the collection")
+	}
+
+	ope, _ := json.Marshal(Operation{Type: "set", Database: "dbname", Collection: "users", Key: "bob", Hash: hash})
+	messages <- string(ope)
+	for i := 0; i < 100; i++ {
+		if keys, _ := users.Keys(); len(keys) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, value, err := users.Get("bob")
+	if err != nil || string(value) != "remote value" {
+		t.Errorf("Sync should apply operations of the collection topic, but %s %v", value, err)
+	}
+
+	users.Set("carol", "local value")
+	db.Set("dave", "root value")
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(published, ",") != "dbname/users" {
+		t.Errorf("Set should publish to the collection topic only, but %v", published)
+	}
+}
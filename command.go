@@ -0,0 +1,81 @@
+package kaleidoscope
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// None is the result type of commands whose output is not needed.
+type None struct{}
+
+// exec runs an IPFS API command and decodes its result according to T:
+// []byte returns the raw body, None discards it and any other type is
+// decoded from a single JSON value.
+func exec[T any](c Client, cmd string, args []string, opts RequestOptions) (T, error) {
+	return send[T](c, NewRequest(c.ipfs.url, cmd, opts, args...))
+}
+
+func send[T any](c Client, req *Request) (T, error) {
+	var out T
+	resp, err := c.call(req)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Close()
+
+	switch v := any(&out).(type) {
+	case *[]byte:
+		*v, err = ioutil.ReadAll(resp.Output)
+		return out, err
+	case *None:
+	default:
+		err = json.NewDecoder(resp.Output).Decode(&out)
+		if err != nil {
+			return out, err
+		}
+	}
+	// Drain the rest so that errors reported in the trailer are not lost.
+	_, err = io.Copy(ioutil.Discard, resp.Output)
+	return out, err
+}
+
+// each decodes a newline-delimited JSON stream and calls fn for every value
+// until the stream ends, fn fails or the daemon reports an error.
+func each[T any](c Client, req *Request, fn func(T) error) error {
+	resp, err := c.call(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	dec := json.NewDecoder(resp.Output)
+	for {
+		var v T
+		err = dec.Decode(&v)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(v)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// call sends the request and turns an error response of the daemon into the
+// returned error, so callers only handle successful responses.
+func (c Client) call(req *Request) (*Response, error) {
+	resp, err := req.Send(c.ipfs.client)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		resp.Close()
+		return nil, resp.Error
+	}
+	return resp, nil
+}
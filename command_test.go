@@ -0,0 +1,62 @@
+package kaleidoscope
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/json":
+			fmt.Fprintln(w, `{"Hash":"QmSomeHash"}`)
+		case "/api/v0/raw":
+			fmt.Fprint(w, strings.Join(r.URL.Query()["arg"], ","))
+		case "/api/v0/none":
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"Message":"unknown command","Code":0}`)
+		}
+	}))
+	defer ipfs.Close()
+	client := testClient(ipfs.URL)
+
+	obj, err := exec[Object](client, "json", nil, RequestOptions{})
+	if err != nil || obj.Hash != "QmSomeHash" {
+		t.Errorf("exec should decode a JSON result, but %v %v", obj, err)
+	}
+	raw, err := exec[[]byte](client, "raw", []string{"a", "b"}, RequestOptions{})
+	if err != nil || string(raw) != "a,b" {
+		t.Errorf("exec should return the raw body and pass args, but %q %v", raw, err)
+	}
+	if _, err := exec[None](client, "none", nil, RequestOptions{}); err != nil {
+		t.Errorf("exec should accept an empty body for None, but %s", err)
+	}
+	if _, err := exec[Object](client, "missing", nil, RequestOptions{}); err == nil {
+		t.Errorf("exec should return the daemon error")
+	}
+}
+
+func TestEachStopsOnCallbackError(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, `{"Hash":"Qm%d"}`+"\n", i)
+		}
+	}))
+	defer ipfs.Close()
+	client := testClient(ipfs.URL)
+
+	seen := 0
+	stop := fmt.Errorf("stop")
+	err := each(client, NewRequest(client.ipfs.url, "stream", RequestOptions{}), func(o Object) error {
+		seen++
+		return stop
+	})
+	if err != stop || seen != 1 {
+		t.Errorf("each should stop at the first callback error, but %v after %d values", err, seen)
+	}
+}
@@ -0,0 +1,95 @@
+package kaleidoscope
+
+type CompactReport struct {
+	Roots    []string
+	Unpinned []string
+	Blocks   int
+	Bytes    int
+}
+
+func (d *DB) Compact(versions int) (CompactReport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	report := CompactReport{Roots: d.versions(versions)}
+
+	reachable := map[string]bool{}
+	for _, root := range report.Roots {
+		reachable[root] = true
+		links, err := d.client.ObjectLinks(root, RequestOptions{})
+		if err != nil {
+			return report, err
+		}
+		for _, link := range links {
+			reachable[link.Hash] = true
+			if !reserved(link.Name) {
+				continue
+			}
+			hashes, err := d.indexObjects(link.Hash)
+			if err != nil {
+				return report, err
+			}
+			for _, hash := range hashes {
+				reachable[hash] = true
+			}
+		}
+	}
+
+	pins := []string{}
+	for _, root := range d.state.Pins {
+		if reachable[root] {
+			pins = append(pins, root)
+			continue
+		}
+		stat, err := d.unpin(root)
+		if err != nil {
+			return report, err
+		}
+		// Values of an old root are accounted for separately below.
+		report.Unpinned = append(report.Unpinned, root)
+		report.Blocks++
+		report.Bytes += stat.BlockSize
+	}
+	d.state.Pins = pins
+
+	objects := []string{}
+	for _, hash := range d.state.Objects {
+		if reachable[hash] {
+			objects = append(objects, hash)
+			continue
+		}
+		stat, err := d.unpin(hash)
+		if err != nil {
+			return report, err
+		}
+		report.Unpinned = append(report.Unpinned, hash)
+		report.Blocks += 1 + stat.NumLinks
+		report.Bytes += stat.CumulativeSize
+	}
+	d.state.Objects = objects
+
+	return report, d.state.Write()
+}
+
+func (d *DB) versions(n int) []string {
+	roots := []string{d.latest()}
+	for i := len(d.state.Pins) - 1; i >= 0 && len(roots) <= n; i-- {
+		if root := d.state.Pins[i]; root != d.latest() {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+func (d *DB) unpin(hash string) (ObjectStat, error) {
+	stat, err := d.client.ObjectStat(hash, RequestOptions{"offline": "true"})
+	if err != nil {
+		// The block is already gone, so there is nothing left to reclaim.
+		stat = ObjectStat{Hash: hash}
+	}
+	_, err = d.client.PinRm(hash, RequestOptions{"recursive": "true"})
+	if err != nil && !isNotPinned(err) {
+		return stat, err
+	}
+	return stat, nil
+}
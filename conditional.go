@@ -0,0 +1,97 @@
+package kaleidoscope
+
+import (
+	"fmt"
+)
+
+type ConflictError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("%s: %s does not exist, expected %s", ErrConflict, e.Key, e.Expected)
+	}
+	if e.Expected == "" {
+		return fmt.Sprintf("%s: %s already exists as %s", ErrConflict, e.Key, e.Actual)
+	}
+	return fmt.Sprintf("%s: %s is %s, expected %s", ErrConflict, e.Key, e.Actual, e.Expected)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+func (d *DB) Hash(key string) (string, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hash, ok, err := d.lookup(d.latest(), key)
+	if err != nil {
+		return "", err
+	}
+	if !ok || d.expiredHash(hash) {
+		return "", &NotFoundError{Key: key, Root: d.latest()}
+	}
+	return hash, nil
+}
+
+func (d *DB) CompareAndSet(key, expected, value string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.guard(key, expected)
+	if err != nil {
+		return "", err
+	}
+	return d.set(key, value)
+}
+
+func (d *DB) SetIfAbsent(key, value string) (string, error) {
+	return d.CompareAndSet(key, "", value)
+}
+
+func (d *DB) DelIfMatch(key, expected string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.guard(key, expected)
+	if err != nil {
+		return "", err
+	}
+	return d.del(key, true)
+}
+
+func (d *DB) guard(key, expected string) error {
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+	actual, _, err := d.lookup(d.latest(), key)
+	if err != nil {
+		return err
+	}
+	if actual != "" && d.expiredHash(actual) {
+		actual = ""
+	}
+	if actual != expected {
+		return &ConflictError{Key: key, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+func (d *DB) lookup(root, key string) (string, bool, error) {
+	links, err := d.links(root)
+	if err != nil {
+		return "", false, err
+	}
+	for _, link := range links {
+		if link.Name == escapeKey(key) {
+			return link.Hash, true, nil
+		}
+	}
+	return "", false, nil
+}
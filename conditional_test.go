@@ -0,0 +1,112 @@
+package kaleidoscope
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testConditionalIPFS() *httptest.Server {
+	return testConditionalIPFSCounting(new(int))
+}
+
+func testConditionalIPFSCounting(adds *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/id":
+			fmt.Fprintln(w, `{"ID":"QmTestPeer"}`)
+		case "/api/v0/object/links":
+			fmt.Fprintln(w, `{"Hash":"QmRoot","Links":[{"Name":"a","Hash":"QmValueA","Size":10}]}`)
+		case "/api/v0/add":
+			*adds++
+			fmt.Fprintln(w, `{"Name":"","Hash":"QmValueB","Size":"67"}`)
+		case "/api/v0/object/patch/add-link", "/api/v0/object/patch/rm-link":
+			fmt.Fprintln(w, `{"Hash":"QmRootAfter"}`)
+		}
+	}))
+}
+
+func TestDBCompareAndSet(t *testing.T) {
+	ipfs := testConditionalIPFS()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	_, err := db.CompareAndSet("a", "QmStale", "1")
+	var conflict *ConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &conflict) || conflict.Actual != "QmValueA" {
+		t.Errorf("CompareAndSet should return ErrConflict with the actual hash, but %v", err)
+	}
+	if db.Head() != "QmRoot" {
+		t.Errorf("CompareAndSet should not change the head on conflict, but %s", db.Head())
+	}
+
+	hash, err := db.CompareAndSet("a", "QmValueA", "1")
+	if err != nil {
+		t.Errorf("CompareAndSet should not return error, but %s", err)
+	}
+	if hash != "QmRootAfter" {
+		t.Errorf("CompareAndSet should return database's hash (QmRootAfter), but %s", hash)
+	}
+}
+
+func TestDBSetIfAbsent(t *testing.T) {
+	ipfs := testConditionalIPFS()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	_, err := db.SetIfAbsent("a", "1")
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("SetIfAbsent should return ErrConflict for an existing key, but %v", err)
+	}
+	_, err = db.SetIfAbsent("b", "1")
+	if err != nil {
+		t.Errorf("SetIfAbsent should not return error, but %s", err)
+	}
+}
+
+func TestDBConditionalValidatesBeforeUpload(t *testing.T) {
+	adds := 0
+	ipfs := testConditionalIPFSCounting(&adds)
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	_, err := db.CompareAndSet("", "", "1")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("CompareAndSet should return ErrInvalidKey for an empty key, but %v", err)
+	}
+	_, err = db.SetIfAbsent("a", "1")
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("SetIfAbsent should return ErrConflict for an existing key, but %v", err)
+	}
+	_, err = db.Set("", "1")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Set should return ErrInvalidKey for an empty key, but %v", err)
+	}
+	if adds != 0 {
+		t.Errorf("Rejected writes should not upload their value, but %d uploads", adds)
+	}
+}
+
+func TestDBDelIfMatch(t *testing.T) {
+	ipfs := testConditionalIPFS()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+
+	_, err := db.DelIfMatch("b", "QmValueB")
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("DelIfMatch should return ErrConflict for a missing key, but %v", err)
+	}
+	_, err = db.DelIfMatch("a", "QmValueA")
+	if err != nil {
+		t.Errorf("DelIfMatch should not return error, but %s", err)
+	}
+}
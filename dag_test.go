@@ -0,0 +1,218 @@
+package kaleidoscope
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// testPeerID has the length of real peer IDs, which count against the RSA
+// budget of every value.
+const testPeerID = "QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N"
+
+type testNode struct {
+	links map[string]string
+	data  []byte
+}
+
+type testDAG struct {
+	nodes map[string]testNode
+	mu    sync.Mutex
+}
+
+func newTestDAG() (*testDAG, *httptest.Server) {
+	dag := &testDAG{nodes: map[string]testNode{
+		EmptyDirMultiHash: {links: map[string]string{}},
+	}}
+	return dag, httptest.NewServer(dag)
+}
+
+func (g *testDAG) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	args := r.URL.Query()["arg"]
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "id":
+		fmt.Fprintf(w, `{"ID":"%s"}`, testPeerID)
+	case "add":
+		err = g.add(w, r)
+	case "cat":
+		var hash string
+		hash, err = g.resolve(args[0])
+		if err == nil {
+			w.Write(g.nodes[hash].data)
+		}
+	case "object/links":
+		var hash string
+		hash, err = g.resolve(args[0])
+		if err == nil {
+			links := []Link{}
+			for _, name := range g.names(hash) {
+				links = append(links, Link{Name: name, Hash: g.nodes[hash].links[name]})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Hash": hash, "Links": links})
+		}
+	case "object/stat":
+		var hash string
+		hash, err = g.resolve(args[0])
+		if err == nil {
+			fmt.Fprintf(w, `{"Hash":"%s","NumLinks":%d}`, hash, len(g.nodes[hash].links))
+		}
+	case "object/put":
+		var hash string
+		hash, err = g.putObject(r)
+		if err == nil {
+			fmt.Fprintf(w, `{"Hash":"%s"}`, hash)
+		}
+	case "object/patch/add-link":
+		var hash string
+		hash, err = g.patch(args[0], strings.Split(args[1], "/"), args[2], r.URL.Query().Get("create") == "true")
+		if err == nil {
+			fmt.Fprintf(w, `{"Hash":"%s"}`, hash)
+		}
+	case "object/patch/rm-link":
+		var hash string
+		hash, err = g.patch(args[0], strings.Split(args[1], "/"), "", false)
+		if err == nil {
+			fmt.Fprintf(w, `{"Hash":"%s"}`, hash)
+		}
+	default:
+		err = fmt.Errorf("unsupported command %s", r.URL.Path)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"Message":%q,"Code":0}`, err.Error())
+	}
+}
+
+func (g *testDAG) add(w http.ResponseWriter, r *http.Request) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	dir := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(part)
+		hash := g.put(testNode{data: data})
+		dir[part.FileName()] = hash
+		fmt.Fprintf(w, `{"Name":"%s","Hash":"%s","Size":"%d"}`+"\n", part.FileName(), hash, len(data))
+	}
+	if r.URL.Query().Get("wrap-with-directory") == "true" {
+		fmt.Fprintf(w, `{"Name":"","Hash":"%s","Size":"0"}`+"\n", g.put(testNode{links: dir}))
+	}
+	return nil
+}
+
+func (g *testDAG) putObject(r *http.Request) (string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", err
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		return "", err
+	}
+	var node Node
+	err = json.NewDecoder(part).Decode(&node)
+	if err != nil {
+		return "", err
+	}
+	links := map[string]string{}
+	for _, link := range node.Links {
+		if _, ok := g.nodes[link.Hash]; !ok {
+			return "", fmt.Errorf("merkledag: not found")
+		}
+		links[link.Name] = link.Hash
+	}
+	return g.put(testNode{links: links}), nil
+}
+
+func (g *testDAG) put(n testNode) string {
+	h := sha256.New()
+	h.Write(n.data)
+	names := []string{}
+	for name := range n.links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "\x00%s\x00%s", name, n.links[name])
+	}
+	hash := "Qm" + hex.EncodeToString(h.Sum(nil))[:20]
+	g.nodes[hash] = n
+	return hash
+}
+
+func (g *testDAG) names(hash string) []string {
+	names := []string{}
+	for name := range g.nodes[hash].links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g *testDAG) resolve(path string) (string, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/ipfs/"), "/"), "/")
+	hash := parts[0]
+	if _, ok := g.nodes[hash]; !ok {
+		return "", fmt.Errorf("merkledag: not found")
+	}
+	for _, name := range parts[1:] {
+		next, ok := g.nodes[hash].links[name]
+		if !ok {
+			return "", fmt.Errorf("no link named %q under %s", name, hash)
+		}
+		hash = next
+	}
+	return hash, nil
+}
+
+func (g *testDAG) patch(root string, path []string, ref string, create bool) (string, error) {
+	node, ok := g.nodes[root]
+	if !ok {
+		return "", fmt.Errorf("merkledag: not found")
+	}
+	links := map[string]string{}
+	for name, hash := range node.links {
+		links[name] = hash
+	}
+	name := path[0]
+	if len(path) == 1 {
+		if ref == "" {
+			if _, ok := links[name]; !ok {
+				return "", fmt.Errorf("no link by that name")
+			}
+			delete(links, name)
+		} else {
+			links[name] = ref
+		}
+		return g.put(testNode{links: links, data: node.data}), nil
+	}
+	child, ok := links[name]
+	if !ok {
+		if !create {
+			return "", fmt.Errorf("no link named %q under %s", name, root)
+		}
+		child = EmptyDirMultiHash
+	}
+	hash, err := g.patch(child, path[1:], ref, create)
+	if err != nil {
+		return "", err
+	}
+	links[name] = hash
+	return g.put(testNode{links: links, data: node.data}), nil
+}
@@ -0,0 +1,117 @@
+package kaleidoscope
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Database describes a database found in the keystore of the IPFS node.
+type Database struct {
+	Name      string
+	ID        string
+	Head      string
+	Published string
+}
+
+// Databases lists every key of the node except its own identity, along
+// with the heads recorded in the local state of each database.
+func (k *Kaleidoscope) Databases() ([]Database, error) {
+	keys, err := k.client.KeyList(RequestOptions{"l": "true"})
+	if err != nil {
+		return []Database{}, err
+	}
+	dbs := []Database{}
+	for _, key := range keys {
+		if key.Name == "self" {
+			continue
+		}
+		state := k.newDB(key.Name).state
+		err := state.Load(key.Name)
+		if err != nil {
+			return []Database{}, err
+		}
+		dbs = append(dbs, Database{Name: key.Name, ID: key.Id, Head: state.Head, Published: state.Published})
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name < dbs[j].Name })
+	return dbs, nil
+}
+
+// Rename renames the key and the local state of a database. The key keeps
+// its identity, so the database stays published under the same IPNS name;
+// the new name is recorded in the database and published by the next Save.
+func (k *Kaleidoscope) Rename(oldname, newname string) (*DB, error) {
+	k.mu.Lock()
+	_, opened := k.dbs[newname]
+	_, inflight := k.pending[newname]
+	if opened || inflight {
+		k.mu.Unlock()
+		return nil, &ExistsError{Database: newname}
+	}
+	k.close(oldname)
+	k.mu.Unlock()
+
+	state := k.newDB(oldname).state
+	err := state.Load(oldname)
+	if err != nil {
+		return nil, err
+	}
+	_, err = k.client.KeyRename(oldname, newname, RequestOptions{})
+	if err != nil {
+		return nil, err
+	}
+	err = state.Rename(newname)
+	if err != nil {
+		return nil, k.unrename(oldname, newname, err)
+	}
+
+	db, err := k.open(newname)
+	if err != nil {
+		if rerr := state.Rename(oldname); rerr != nil {
+			return nil, fmt.Errorf("%w (failed to restore the state of %s: %v)", err, oldname, rerr)
+		}
+		return nil, k.unrename(oldname, newname, err)
+	}
+	_, err = db.Set("__database_name", newname)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// unrename gives the key back its old name when the database could not be
+// opened under the new one, so that it stays reachable.
+func (k *Kaleidoscope) unrename(oldname, newname string, err error) error {
+	_, rerr := k.client.KeyRename(newname, oldname, RequestOptions{})
+	if rerr != nil {
+		return fmt.Errorf("%w (failed to rename %s back to %s: %v)", err, newname, oldname, rerr)
+	}
+	return err
+}
+
+// Drop removes the key of the database, unpins everything it pinned on
+// this node and forgets its local state. The key goes first so that the
+// database can not be published again once its objects are unpinned; the
+// last published record expires on its own.
+func (k *Kaleidoscope) Drop(dbname string) error {
+	k.Close(dbname)
+
+	db := k.newDB(dbname)
+	err := db.state.Load(dbname)
+	if err != nil {
+		return err
+	}
+	pins := append(db.state.Pins, db.state.Objects...)
+	_, err = k.client.KeyRm(dbname, RequestOptions{})
+	// The key is already gone when a previous Drop failed to unpin.
+	if err != nil && !(errors.Is(err, ErrNotFound) && len(pins) > 0) {
+		return err
+	}
+	for _, hash := range pins {
+		_, err := k.client.PinRm(hash, RequestOptions{"recursive": "true"})
+		if err != nil && !isNotPinned(err) {
+			return err
+		}
+	}
+	return db.state.Remove()
+}
@@ -0,0 +1,189 @@
+package kaleidoscope
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKaleidoScopeDatabases(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/key/list" || r.URL.Query().Get("l") != "true" {
+			t.Errorf("Databases should list keys with their IDs, but %s", r.URL)
+		}
+		fmt.Fprintln(w, `{"Keys":[{"Name":"self","Id":"QmSelf"},{"Name":"users","Id":"QmUsers"},{"Name":"orders","Id":"QmOrders"}]}`)
+	}))
+	defer ipfs.Close()
+
+	dbs, err := testKaleidoScope(ipfs.URL).Databases()
+	if err != nil {
+		t.Errorf("Databases should not return error, but %s", err)
+	}
+	if len(dbs) != 2 || dbs[0].Name != "orders" || dbs[0].ID != "QmOrders" || dbs[1].Name != "users" {
+		t.Errorf("Databases should list keys except self in order, but %v", dbs)
+	}
+}
+
+func TestKaleidoScopeRename(t *testing.T) {
+	dag, unused := newTestDAG()
+	unused.Close()
+	var renamed []string
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/key/rename":
+			renamed = r.URL.Query()["arg"]
+			fmt.Fprintf(w, `{"Was":"%s","Now":"%s","Id":"QmID","Overwrite":false}`, renamed[0], renamed[1])
+		case "/api/v0/name/resolve":
+			fmt.Fprintf(w, `{"Path":"%s"}`, EmptyDirMultiHash)
+		default:
+			dag.ServeHTTP(w, r)
+		}
+	}))
+	defer ipfs.Close()
+
+	kes := testKaleidoScope(ipfs.URL)
+	db, err := kes.Rename("old", "new")
+	if err != nil {
+		t.Fatalf("Rename should not return error, but %s", err)
+	}
+	if strings.Join(renamed, ",") != "old,new" {
+		t.Errorf("Rename should rename the key, but %v", renamed)
+	}
+	if db.Name() != "new" {
+		t.Errorf("Rename should open the database under the new name, but %s", db.Name())
+	}
+	_, value, _ := db.Get("__database_name")
+	if string(value) != "new" {
+		t.Errorf("Rename should record the new name, but %s", value)
+	}
+}
+
+func TestKaleidoScopeRenameRollback(t *testing.T) {
+	var renamed []string
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/key/rename":
+			args := r.URL.Query()["arg"]
+			renamed = append(renamed, args...)
+			fmt.Fprintf(w, `{"Was":"%s","Now":"%s","Id":"QmID","Overwrite":false}`, args[0], args[1])
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"Message":"some failure","Code":0}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	kes := testKaleidoScope(ipfs.URL)
+	_, err := kes.Rename("old", "new")
+	if err == nil {
+		t.Fatalf("Rename should return error when the database can not be opened")
+	}
+	if strings.Join(renamed, ",") != "old,new,new,old" {
+		t.Errorf("Rename should rename the key back, but %v", renamed)
+	}
+	if dbs := kes.Opened(); len(dbs) != 0 {
+		t.Errorf("Rename should not leave the database open, but %v", dbs)
+	}
+}
+
+func TestKaleidoScopeDropRemovesKeyFirst(t *testing.T) {
+	t.Setenv(EnvDir, t.TempDir())
+	state := NewState()
+	state.Load("dbname")
+	state.Pins = []string{"QmRoot"}
+	state.Write()
+
+	var mu sync.Mutex
+	calls := []string{}
+	keyErr, pinErr := "some failure", "some failure"
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, strings.TrimPrefix(r.URL.Path, "/api/v0/"))
+		fail := func(msg string) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"Message":"%s","Code":0}`, msg)
+		}
+		switch r.URL.Path {
+		case "/api/v0/key/rm":
+			if keyErr != "" {
+				fail(keyErr)
+				return
+			}
+			fmt.Fprintln(w, `{"Keys":[{"Name":"dbname","Id":"QmID"}]}`)
+			keyErr = "no key named dbname was found"
+		case "/api/v0/pin/rm":
+			if pinErr != "" {
+				fail(pinErr)
+				return
+			}
+			fmt.Fprintln(w, `{"Pins":["QmRoot"]}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	kes := newKaleidoscope(testClient(ipfs.URL))
+	if err := kes.Drop("dbname"); err == nil || strings.Join(calls, ",") != "key/rm" {
+		t.Errorf("Drop should not unpin when the key can not be removed, but %v %v", err, calls)
+	}
+
+	mu.Lock()
+	calls, keyErr = nil, ""
+	mu.Unlock()
+	if err := kes.Drop("dbname"); err == nil || strings.Join(calls, ",") != "key/rm,pin/rm" {
+		t.Errorf("Drop should unpin after removing the key, but %v %v", err, calls)
+	}
+
+	mu.Lock()
+	calls, pinErr = nil, ""
+	mu.Unlock()
+	if err := kes.Drop("dbname"); err != nil || strings.Join(calls, ",") != "key/rm,pin/rm" {
+		t.Errorf("Drop should finish unpinning once the key is gone, but %v %v", err, calls)
+	}
+	file, _ := stateFile("dbname")
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Drop should remove the local state")
+	}
+}
+
+func TestKaleidoScopeDrop(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, strings.TrimPrefix(r.URL.Path, "/api/v0/"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v0/key/rm":
+			if r.URL.Query().Get("arg") == "missing" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"Message":"no key named missing was found","Code":0}`)
+				return
+			}
+			fmt.Fprintln(w, `{"Keys":[{"Name":"dbname","Id":"QmID"}]}`)
+		default:
+			fmt.Fprintln(w, `{"Pins":[]}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	kes := testKaleidoScope(ipfs.URL)
+	err := kes.Drop("dbname")
+	if err != nil {
+		t.Errorf("Drop should not return error, but %s", err)
+	}
+	if strings.Join(calls, ",") != "key/rm" {
+		t.Errorf("Drop should remove the key, but %v", calls)
+	}
+	if err := kes.Drop("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Drop should return ErrNotFound for a missing database, but %v", err)
+	}
+}
@@ -0,0 +1,689 @@
+package kaleidoscope
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type DB struct {
+	name      string
+	head      string
+	client    Client
+	keystore  Keystore
+	state     State
+	stream    Stream
+	streams   map[string]Stream
+	retention int
+	cache     *Cache
+	plaintext bool
+	recovery  Recovery
+	names     NameOptions
+	watchers  map[chan Event]struct{}
+	expiries  map[string]int64
+	peer      string
+	sweeper   chan struct{}
+	mu        sync.Mutex
+	wmu       sync.Mutex
+	cmu       sync.Mutex
+	emu       sync.Mutex
+}
+
+func newDB(client Client, dbname string) *DB {
+	return &DB{
+		name:      dbname,
+		client:    client,
+		keystore:  NewKeyStore(),
+		state:     NewState(),
+		retention: DefaultRetention,
+		plaintext: true,
+	}
+}
+
+func (d *DB) load() error {
+	err := d.keystore.Load(d.name)
+	if err != nil {
+		return err
+	}
+	return d.state.Load(d.name)
+}
+
+func (d *DB) resolve() error {
+	ipns, err := d.keystore.PeerID()
+	if err != nil {
+		return err
+	}
+	head, err := d.client.NameResolve(ipns, d.names.resolve())
+	if err != nil {
+		if d.names.Fallback && d.state.Published != "" && timedOut(err) {
+			d.use(d.state.Published)
+			return d.replay(d.state.Published, SourceLocal)
+		}
+		return err
+	}
+	d.use(head)
+	return d.replay(head, SourceIPNS)
+}
+
+func (d *DB) replay(published, source string) error {
+	d.recovery = Recovery{Published: published, Head: published, Source: source}
+	for _, ope := range d.state.WAL {
+		hash, err := d.commit(d.latest(), ope)
+		if err != nil {
+			return err
+		}
+		d.use(hash)
+		d.recovery.Replayed++
+	}
+	d.recovery.Head = d.latest()
+	if d.recovery.Replayed == 0 {
+		return nil
+	}
+	d.state.Head = d.latest()
+	return d.state.Write()
+}
+
+func (d *DB) Recovery() Recovery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.recovery
+}
+
+func (d *DB) Name() string {
+	return d.name
+}
+
+func (d *DB) Head() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.latest()
+}
+
+func (d *DB) Set(key, value string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.set(key, value)
+}
+
+func (d *DB) Get(key string) ([]byte, []byte, error) {
+	return d.get(d.Head(), key)
+}
+
+func (d *DB) get(root, key string) ([]byte, []byte, error) {
+	m, value, err := d.value(root, key)
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+	return m.timestamp, value, nil
+}
+
+func (d *DB) value(root, key string) (metadata, []byte, error) {
+	hash, plain, err := d.read(root, key)
+	if err != nil {
+		return metadata{}, []byte{}, err
+	}
+	m, value, err := unwrap(plain)
+	if err != nil {
+		return metadata{}, []byte{}, err
+	}
+	if hash != "" {
+		d.remember(hash, m.expires)
+	}
+	if expired(m.expires) {
+		return metadata{}, []byte{}, &NotFoundError{Key: key, Root: root}
+	}
+	if m.layout == chunkedLayout {
+		value, err = d.assemble(chunkBase(hash, root, key), value)
+	}
+	return m, value, err
+}
+
+func (d *DB) read(root, key string) (string, []byte, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	cache, _ := d.cacheConfig()
+	if cache == nil {
+		enc, err := d.client.Cat(root+"/"+escapeKey(key)+"/value", RequestOptions{})
+		if err != nil {
+			return "", []byte{}, err
+		}
+		plain, err := d.keystore.Decrypt(enc)
+		return "", plain, err
+	}
+
+	hash, err := d.locate(cache, root, key)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	plain, err := d.plain(hash)
+	return hash, plain, err
+}
+
+// locate resolves only the link of the key, roots are immutable so the hash
+// is cached by the path.
+func (d *DB) locate(cache *Cache, root, key string) (string, error) {
+	name := root + "/" + escapeKey(key)
+	if hash, ok := cache.Get("link:" + name); ok {
+		return hash.(string), nil
+	}
+	stat, err := d.client.ObjectStat(name, RequestOptions{})
+	if errors.Is(err, ErrNotFound) {
+		return "", &NotFoundError{Key: key, Root: root}
+	}
+	if err != nil {
+		return "", err
+	}
+	cache.Add("link:"+name, stat.Hash, len(name)+len(stat.Hash))
+	return stat.Hash, nil
+}
+
+func (d *DB) plain(hash string) ([]byte, error) {
+	cache, plaintext := d.cacheConfig()
+	if cache == nil {
+		enc, err := d.client.Cat(hash+"/value", RequestOptions{})
+		if err != nil {
+			return []byte{}, err
+		}
+		return d.keystore.Decrypt(enc)
+	}
+
+	// Callers own the returned bytes, so the cache never hands out its own.
+	if plaintext {
+		if plain, ok := cache.Get("plain:" + hash); ok {
+			return append([]byte(nil), plain.([]byte)...), nil
+		}
+	}
+	var enc []byte
+	if cached, ok := cache.Get("enc:" + hash); ok {
+		enc = cached.([]byte)
+	} else {
+		var err error
+		enc, err = d.client.Cat(hash+"/value", RequestOptions{})
+		if err != nil {
+			return []byte{}, err
+		}
+		cache.Add("enc:"+hash, enc, len(enc))
+	}
+	plain, err := d.keystore.Decrypt(enc)
+	if err != nil {
+		return []byte{}, err
+	}
+	if plaintext {
+		cache.Add("plain:"+hash, append([]byte(nil), plain...), len(plain))
+	}
+	return plain, nil
+}
+
+func (d *DB) links(root string) ([]Link, error) {
+	cache, _ := d.cacheConfig()
+	if cache != nil {
+		if links, ok := cache.Get("links:" + root); ok {
+			return links.([]Link), nil
+		}
+	}
+	links, err := d.client.ObjectLinks(root, RequestOptions{})
+	if err != nil {
+		return []Link{}, err
+	}
+	if cache != nil {
+		size := 0
+		for _, link := range links {
+			size += len(link.Name) + len(link.Hash)
+		}
+		cache.Add("links:"+root, links, size)
+	}
+	return links, nil
+}
+
+func (d *DB) SetCache(c *Cache) {
+	d.cmu.Lock()
+	defer d.cmu.Unlock()
+	d.cache = c
+}
+
+func (d *DB) SetNameOptions(o NameOptions) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.names = o
+}
+
+func (d *DB) SetPlaintextCache(enabled bool) {
+	d.cmu.Lock()
+	defer d.cmu.Unlock()
+	d.plaintext = enabled
+}
+
+func (d *DB) writer() (string, error) {
+	d.cmu.Lock()
+	peer := d.peer
+	d.cmu.Unlock()
+	if peer != "" {
+		return peer, nil
+	}
+	id, err := d.client.ID(RequestOptions{})
+	if err != nil {
+		return "", err
+	}
+	d.cmu.Lock()
+	defer d.cmu.Unlock()
+	d.peer = id.ID
+	return d.peer, nil
+}
+
+func (d *DB) cacheConfig() (*Cache, bool) {
+	d.cmu.Lock()
+	defer d.cmu.Unlock()
+	return d.cache, d.plaintext
+}
+
+func (d *DB) Keys() ([]string, error) {
+	links, err := d.links(d.Head())
+	if err != nil {
+		return []string{}, err
+	}
+	keys := make([]string, 0, len(links))
+	for _, link := range links {
+		if reserved(link.Name) || d.expiredHash(link.Hash) {
+			continue
+		}
+		keys = append(keys, unescapeKey(link.Name))
+	}
+	return keys, nil
+}
+
+// SetBatch uploads all values first and then links them into the database
+// with a single directory write, logged as one batch operation. Records of
+// collections are then set one by one. Timestamps and writers of the records
+// are kept, so dumped records load unchanged.
+func (d *DB) SetBatch(records []Record) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range records {
+		err := checkKey(r.Key)
+		if err == nil && r.Collection != "" {
+			err = checkKey(r.Collection)
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := strconv.ParseInt(r.Timestamp, 10, 64); r.Timestamp != "" && err != nil {
+			return "", fmt.Errorf("Invalid timestamp of %s: %s", r.Key, r.Timestamp)
+		}
+		if strings.ContainsAny(r.Writer, ";,") {
+			return "", fmt.Errorf("Invalid writer of %s: %s", r.Key, r.Writer)
+		}
+	}
+	err := d.purge("")
+	if err != nil {
+		return "", err
+	}
+	entries := make([]BatchEntry, 0, len(records))
+	var collected []Operation
+	index := map[string]int{}
+	for _, r := range records {
+		m := metadata{expires: r.Expires, codec: r.Codec, writer: r.Writer}
+		if r.Timestamp != "" {
+			m.timestamp = []byte(r.Timestamp)
+		}
+		hash, err := d.addWithMetadata(r.Value, m)
+		if err != nil {
+			return "", err
+		}
+		if r.Collection != "" {
+			collected = append(collected, Operation{Type: "set", Collection: r.Collection, Key: r.Key, Hash: hash})
+			continue
+		}
+		if i, ok := index[r.Key]; ok {
+			entries[i].Hash = hash
+			continue
+		}
+		index[r.Key] = len(entries)
+		entries = append(entries, BatchEntry{Key: r.Key, Hash: hash})
+	}
+	head := d.latest()
+	if len(entries) > 0 {
+		head, err = d.apply(Operation{Type: "batch", Entries: entries}, true)
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, ope := range collected {
+		head, err = d.apply(ope, true)
+		if err != nil {
+			return "", err
+		}
+	}
+	return head, nil
+}
+
+func (d *DB) Del(key string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.del(key, true)
+}
+
+func (d *DB) del(key string, pub bool) (string, error) {
+	if pub {
+		err := d.purge(key)
+		if err != nil {
+			return "", err
+		}
+	}
+	return d.apply(Operation{Type: "del", Key: key}, pub)
+}
+
+func (d *DB) Save() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, _, err := d.client.NamePublish(d.head, d.names.publish(d.name))
+	if err != nil {
+		return err
+	}
+	// The log goes first: replaying it onto the new record would fail,
+	// while an outdated state file is corrected by resolving the record.
+	err = d.state.TruncateWAL()
+	if err != nil {
+		return err
+	}
+	d.state.Head = d.head
+	d.state.Published = d.head
+	d.recovery = Recovery{Published: d.head, Head: d.head, Source: SourceIPNS}
+	err = d.state.Write()
+	if err != nil {
+		return err
+	}
+	err = d.pin(d.head)
+	if err != nil {
+		return err
+	}
+	return d.release(d.head)
+}
+
+func (d *DB) StartSync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.startSync("")
+}
+
+func (d *DB) startSync(collection string) error {
+	if d.syncStream(collection).IsRunning() {
+		return nil
+	}
+	stream, err := d.client.PubSubSub(d.topic(collection), RequestOptions{"discover": "true"})
+	if err != nil {
+		return err
+	}
+	d.setSyncStream(collection, stream)
+	go func() {
+		for data := range stream.Data {
+			var ope Operation
+			err := json.NewDecoder(strings.NewReader(data)).Decode(&ope)
+			if err != nil {
+				continue
+			}
+			if ope.Database != d.name || ope.Collection != collection {
+				continue
+			}
+			ope.Type = strings.ToLower(ope.Type)
+			if ope.Type == "del" {
+				ope.Hash = ""
+			} else if ope.Type != "set" && ope.Type != "batch" {
+				continue
+			}
+			func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				d.apply(ope, false)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (d *DB) StopSync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stream.Close()
+	for name, stream := range d.streams {
+		stream.Close()
+		delete(d.streams, name)
+	}
+}
+
+func (d *DB) Syncing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stream.IsRunning()
+}
+
+func (d *DB) topic(collection string) string {
+	if collection == "" {
+		return d.name
+	}
+	return d.name + "/" + collection
+}
+
+func (d *DB) syncStream(collection string) Stream {
+	if collection == "" {
+		return d.stream
+	}
+	return d.streams[collection]
+}
+
+func (d *DB) setSyncStream(collection string, stream Stream) {
+	if collection == "" {
+		d.stream = stream
+		return
+	}
+	if d.streams == nil {
+		d.streams = map[string]Stream{}
+	}
+	d.streams[collection] = stream
+}
+
+// checkpointInterval is the number of logged operations after which the
+// state file is written again.
+const checkpointInterval = 100
+
+type Operation struct {
+	Type       string
+	Database   string
+	Collection string
+	Key        string
+	Hash       string
+	Entries    []BatchEntry `json:",omitempty"`
+}
+
+type BatchEntry struct {
+	Key  string
+	Hash string
+}
+
+type Recovery struct {
+	Published string
+	Head      string
+	Replayed  int
+	Source    string
+}
+
+func (r Recovery) Ahead() bool {
+	return r.Head != r.Published
+}
+
+// Local reports whether the database was opened at the locally persisted
+// head because its IPNS record could not be resolved in time.
+func (r Recovery) Local() bool {
+	return r.Source == SourceLocal
+}
+
+func (d *DB) set(key, value string) (string, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+	err = d.purge(key)
+	if err != nil {
+		return "", err
+	}
+	hash, err := d.add(value)
+	if err != nil {
+		return "", err
+	}
+	return d.setHash(key, hash, true)
+}
+
+func (d *DB) add(value string) (string, error) {
+	return d.addWithMetadata(value, metadata{})
+}
+
+func (d *DB) addWithMetadata(value string, m metadata) (string, error) {
+	if m.writer == "" {
+		var err error
+		m.writer, err = d.writer()
+		if err != nil {
+			return "", err
+		}
+	}
+	var hash string
+	enc, err := d.keystore.EncryptString(wrap(value, m))
+	if errors.Is(err, rsa.ErrMessageTooLong) {
+		// The value does not fit the RSA key along with its metadata, so
+		// it is sealed with its own AES key like streamed values.
+		hash, err = d.upload(strings.NewReader(value), m)
+	} else if err == nil {
+		hash, err = d.client.Add("value", bytes.NewReader(enc),
+			RequestOptions{"wrap-with-directory": "true"})
+	}
+	if err != nil {
+		return "", err
+	}
+	d.state.Objects = append(d.state.Objects, hash)
+	d.remember(hash, m.expires)
+	return hash, nil
+}
+
+func (d *DB) setHash(key, hash string, pub bool) (string, error) {
+	return d.apply(Operation{Type: "set", Key: key, Hash: hash}, pub)
+}
+
+func (d *DB) apply(ope Operation, pub bool) (string, error) {
+	switch ope.Type {
+	case "reset":
+	case "batch":
+		for _, e := range ope.Entries {
+			err := checkKey(e.Key)
+			if err != nil {
+				return "", err
+			}
+		}
+	default:
+		err := checkKey(ope.Key)
+		if err != nil {
+			return "", err
+		}
+	}
+	ope.Database = d.name
+	dbhash, err := d.commit(d.latest(), ope)
+	if err != nil {
+		return "", err
+	}
+	// The new root is only reachable once the operation is logged, so a
+	// failed commit leaves nothing to roll back.
+	err = d.state.Append(ope)
+	if err != nil {
+		return "", err
+	}
+
+	d.use(dbhash)
+	d.state.Head = dbhash
+	if len(d.state.WAL)%checkpointInterval == 0 {
+		err = d.state.Write()
+		if err != nil {
+			return "", err
+		}
+	}
+	d.recovery.Head = dbhash
+	d.notify(ope)
+	if pub && d.syncStream(ope.Collection).IsRunning() {
+		json, err := json.Marshal(ope)
+		if err != nil {
+			return "", err
+		}
+		err = d.client.PubSubPub(d.topic(ope.Collection), string(json), RequestOptions{})
+		if err != nil {
+			// The operation is already committed locally, so the new head is
+			// returned along with the error and peers catch up on the next save.
+			return dbhash, fmt.Errorf("Failed to publish %s of %s: %w", ope.Type, ope.Key, err)
+		}
+	}
+	return dbhash, nil
+}
+
+func (d *DB) commit(root string, ope Operation) (string, error) {
+	hash, err := d.patch(root, ope)
+	if err != nil {
+		return "", err
+	}
+	return d.reindex(root, hash, ope)
+}
+
+func (d *DB) patch(root string, ope Operation) (string, error) {
+	switch {
+	case ope.Collection != "" && ope.Type == "set":
+		return d.client.ObjectPatchAddLink(root, collectionPath(ope.Collection, ope.Key), ope.Hash, RequestOptions{"create": "true"})
+	case ope.Collection != "" && ope.Type == "del":
+		return d.client.ObjectPatchRmLink(root, collectionPath(ope.Collection, ope.Key), RequestOptions{})
+	}
+	switch ope.Type {
+	case "set":
+		return d.client.ObjectPatchAddLink(root, escapeKey(ope.Key), ope.Hash, RequestOptions{})
+	case "del":
+		return d.client.ObjectPatchRmLink(root, escapeKey(ope.Key), RequestOptions{})
+	case "batch":
+		return d.link(root, ope.Entries)
+	case "reset":
+		return ope.Hash, nil
+	}
+	return "", fmt.Errorf("Unknown operation: %s", ope.Type)
+}
+
+// unixfsDirectory is the base64 encoded data of an empty UnixFS directory.
+const unixfsDirectory = "CAE="
+
+// link writes root with the links of entries added in one object/put
+// instead of patching the links one by one.
+func (d *DB) link(root string, entries []BatchEntry) (string, error) {
+	links, err := d.links(root)
+	if err != nil {
+		return "", err
+	}
+	merged := map[string]Link{}
+	for _, link := range links {
+		merged[link.Name] = link
+	}
+	for _, e := range entries {
+		merged[escapeKey(e.Key)] = Link{Name: escapeKey(e.Key), Hash: e.Hash}
+	}
+	node := Node{Data: unixfsDirectory, Links: make([]Link, 0, len(merged))}
+	for _, link := range merged {
+		node.Links = append(node.Links, link)
+	}
+	sort.Slice(node.Links, func(i, j int) bool { return node.Links[i].Name < node.Links[j].Name })
+	return d.client.ObjectPut(node, RequestOptions{"inputenc": "json", "datafieldenc": "base64"})
+}
+
+func (d *DB) use(head string) {
+	d.head = head
+}
+
+func (d *DB) latest() string {
+	return d.head
+}
@@ -0,0 +1,84 @@
+package kaleidoscope
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrNoDatabase        = errors.New("no database")
+	ErrDecrypt           = errors.New("decryption failed")
+	ErrDaemonUnavailable = errors.New("IPFS daemon unavailable")
+	ErrConflict          = errors.New("conflict")
+	ErrExists            = errors.New("already exists")
+)
+
+type NotFoundError struct {
+	Key  string
+	Root string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("no link named %q under %s", e.Key, e.Root)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+type DatabaseError struct {
+	Database string
+	Err      error
+}
+
+func (e *DatabaseError) Error() string {
+	if e.Database == "" {
+		return "No database selected. Use or create a database first."
+	}
+	return fmt.Sprintf("Database %s does not exist: %s", e.Database, e.Err)
+}
+
+func (e *DatabaseError) Is(target error) bool {
+	return target == ErrNoDatabase
+}
+
+func (e *DatabaseError) Unwrap() error {
+	return e.Err
+}
+
+// ExistsError is returned for a database that is already open. It is a
+// conflict, so callers handling ErrConflict keep working.
+type ExistsError struct {
+	Database string
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("Database %s already exists", e.Database)
+}
+
+func (e *ExistsError) Is(target error) bool {
+	return target == ErrExists || target == ErrConflict
+}
+
+// classify maps an IPFS API error response to one of the sentinel errors.
+// The daemon reports most failures as 500 with a message, so the message
+// is matched against the phrases go-ipfs uses.
+func classify(status int, message string) error {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrDaemonUnavailable
+	}
+	msg := strings.ToLower(message)
+	for _, phrase := range []string{"no link named", "no link by that name", "no key named", "merkledag: not found", "no such", "could not resolve", "not found"} {
+		if strings.Contains(msg, phrase) && msg != "command not found" {
+			return ErrNotFound
+		}
+	}
+	if strings.Contains(msg, "already exists") {
+		return ErrConflict
+	}
+	return nil
+}
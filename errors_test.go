@@ -0,0 +1,138 @@
+package kaleidoscope
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestSendClassifiesErrors(t *testing.T) {
+	cases := []struct {
+		status  int
+		message string
+		expect  error
+	}{
+		{http.StatusInternalServerError, `no link named "key" under QmRoot`, ErrNotFound},
+		{http.StatusInternalServerError, "no link by that name", ErrNotFound},
+		{http.StatusInternalServerError, "merkledag: not found", ErrNotFound},
+		{http.StatusInternalServerError, "could not resolve name", ErrNotFound},
+		{http.StatusInternalServerError, "key with name 'db' already exists", ErrConflict},
+		{http.StatusServiceUnavailable, "daemon is starting", ErrDaemonUnavailable},
+		{http.StatusInternalServerError, "some failure", nil},
+	}
+	for _, c := range cases {
+		ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(c.status)
+			fmt.Fprintf(w, `{"Message":%q,"Code":0}`, c.message)
+		}))
+		_, err := testClient(ipfs.URL).Cat("QmRoot/key/value", RequestOptions{})
+		ipfs.Close()
+
+		var ierr *Error
+		if !errors.As(err, &ierr) || ierr.Message != c.message {
+			t.Errorf("Cat should return an *Error with the daemon message %q, but %v", c.message, err)
+		}
+		if c.expect != nil && !errors.Is(err, c.expect) {
+			t.Errorf("Error %q should match %v", c.message, c.expect)
+		}
+		if c.expect == nil && errors.Is(err, ErrNotFound) {
+			t.Errorf("Error %q should not match ErrNotFound", c.message)
+		}
+	}
+}
+
+func TestRequestSendDaemonUnavailable(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := ipfs.URL
+	ipfs.Close()
+
+	_, err := testClient(url).Cat("QmRoot", RequestOptions{})
+	if !errors.Is(err, ErrDaemonUnavailable) {
+		t.Errorf("Cat should return ErrDaemonUnavailable when the daemon is down, but %v", err)
+	}
+}
+
+func TestDBErrors(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.Set("key", "value")
+
+	_, _, err := db.Get("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get should return ErrNotFound for a missing key, but %v", err)
+	}
+	_, err = db.Del("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Del should return ErrNotFound for a missing key, but %v", err)
+	}
+	db.SetCache(NewCache(1 << 20))
+	_, _, err = db.Get("missing")
+	var nerr *NotFoundError
+	if !errors.As(err, &nerr) || nerr.Key != "missing" {
+		t.Errorf("Get should return a NotFoundError with the key, but %v", err)
+	}
+
+	db.keystore = NewKeyStore()
+	db.keystore.persistence = false
+	db.keystore.Load("other")
+	db.SetCache(nil)
+	_, _, err = db.Get("key")
+	if !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Get should return ErrDecrypt with another key, but %v", err)
+	}
+}
+
+func TestKaleidoscopeNoDatabase(t *testing.T) {
+	kes := newKaleidoscope(testClient("localhost:5001"))
+	_, err := kes.Current()
+	if !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("Current should return ErrNoDatabase without a database, but %v", err)
+	}
+	_, _, err = kes.Get("key")
+	if !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("Get should return ErrNoDatabase without a database, but %v", err)
+	}
+}
+
+func TestDBSetReportsPublishError(t *testing.T) {
+	dag, unused := newTestDAG()
+	unused.Close()
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/pubsub/sub":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/api/v0/pubsub/pub":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"Message":"experimental pubsub feature not enabled","Code":0}`)
+		default:
+			dag.ServeHTTP(w, r)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	err := db.StartSync()
+	if err != nil {
+		t.Fatalf("StartSync should not return error, but %s", err)
+	}
+	defer db.StopSync()
+
+	head, err := db.Set("key", "value")
+	var ierr *Error
+	if !errors.As(err, &ierr) || ierr.Command != "pubsub/pub" {
+		t.Errorf("Set should return the publish error, but %v", err)
+	}
+	if head == "" || head != db.Head() {
+		t.Errorf("Set should still commit the operation locally, but %s", head)
+	}
+}
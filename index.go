@@ -0,0 +1,536 @@
+package kaleidoscope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const indexRoot = "__indexes"
+
+type indexBucket struct {
+	Value json.RawMessage
+	Keys  []string
+}
+
+func reserved(name string) bool {
+	return name == indexRoot || name == collectionRoot
+}
+
+func (d *DB) Indexes() map[string]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	indexes := map[string]string{}
+	for name, field := range d.state.Indexes {
+		indexes[name] = field
+	}
+	return indexes
+}
+
+func (d *DB) DefineIndex(name, field string) (int, error) {
+	if name == "" || field == "" {
+		return 0, fmt.Errorf("Index name and field must not be empty")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state.Indexes == nil {
+		d.state.Indexes = map[string]string{}
+	}
+	d.state.Indexes[name] = field
+	err := d.state.Write()
+	if err != nil {
+		return 0, err
+	}
+	return d.rebuild(name)
+}
+
+func (d *DB) DropIndex(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.state.Indexes[name]; !ok {
+		return fmt.Errorf("Unknown index: %s", name)
+	}
+	delete(d.state.Indexes, name)
+	err := d.state.Write()
+	if err != nil {
+		return err
+	}
+	root, err := d.clearIndex(d.latest(), name)
+	if err != nil || root == d.latest() {
+		return err
+	}
+	_, err = d.apply(Operation{Type: "reset", Hash: root}, false)
+	return err
+}
+
+func (d *DB) RebuildIndex(name string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rebuild(name)
+}
+
+func (d *DB) RebuildIndexes() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rebuildAll()
+}
+
+func (d *DB) Query(index string, value interface{}) ([]string, error) {
+	d.mu.Lock()
+	root := d.latest()
+	_, ok := d.state.Indexes[index]
+	d.mu.Unlock()
+	if !ok {
+		return []string{}, fmt.Errorf("Unknown index: %s", index)
+	}
+
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return []string{}, err
+	}
+	bucket, err := d.readBucket(root, index, string(canonical))
+	if err != nil {
+		return []string{}, err
+	}
+	return d.live(root, bucket.Keys)
+}
+
+func (d *DB) QueryRange(index string, min, max interface{}) ([]string, error) {
+	d.mu.Lock()
+	root := d.latest()
+	_, ok := d.state.Indexes[index]
+	d.mu.Unlock()
+	if !ok {
+		return []string{}, fmt.Errorf("Unknown index: %s", index)
+	}
+
+	dir, err := d.indexDir(index)
+	if err != nil {
+		return []string{}, err
+	}
+	hash, ok, err := d.walk(root, indexRoot, dir)
+	if err != nil || !ok {
+		return []string{}, err
+	}
+	links, err := d.links(hash)
+	if err != nil {
+		return []string{}, err
+	}
+
+	type match struct {
+		value interface{}
+		keys  []string
+	}
+	matches := []match{}
+	for _, link := range links {
+		data, err := d.blob(link.Hash)
+		if err != nil {
+			return []string{}, err
+		}
+		var bucket indexBucket
+		err = json.Unmarshal(data, &bucket)
+		if err != nil {
+			return []string{}, err
+		}
+		var value interface{}
+		err = json.Unmarshal(bucket.Value, &value)
+		if err != nil {
+			return []string{}, err
+		}
+		if !within(value, min, max) {
+			continue
+		}
+		matches = append(matches, match{value: value, keys: bucket.Keys})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		c, _ := compare(matches[i].value, matches[j].value)
+		return c < 0
+	})
+	keys := []string{}
+	for _, m := range matches {
+		keys = append(keys, m.keys...)
+	}
+	return d.live(root, keys)
+}
+
+func (d *DB) live(root string, keys []string) ([]string, error) {
+	result := []string{}
+	for _, key := range keys {
+		hash, ok, err := d.lookup(root, key)
+		if err != nil {
+			return []string{}, err
+		}
+		if ok && !d.expiredHash(hash) {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+func (d *DB) rebuildAll() (int, error) {
+	n := 0
+	for _, name := range d.indexNames() {
+		m, err := d.rebuild(name)
+		if err != nil {
+			return n, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+func (d *DB) rebuild(name string) (int, error) {
+	field, ok := d.state.Indexes[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown index: %s", name)
+	}
+	root, err := d.clearIndex(d.latest(), name)
+	if err != nil {
+		return 0, err
+	}
+	links, err := d.links(root)
+	if err != nil {
+		return 0, err
+	}
+
+	buckets := map[string]*indexBucket{}
+	n := 0
+	for _, link := range links {
+		if reserved(link.Name) {
+			continue
+		}
+		value, ok, err := d.indexValue(link.Hash, field)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			continue
+		}
+		if _, ok := buckets[value]; !ok {
+			buckets[value] = &indexBucket{Value: json.RawMessage(value)}
+		}
+		buckets[value].Keys = append(buckets[value].Keys, unescapeKey(link.Name))
+		n++
+	}
+	values := make([]string, 0, len(buckets))
+	for value := range buckets {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		sort.Strings(buckets[value].Keys)
+		root, err = d.writeBucket(root, name, value, *buckets[value])
+		if err != nil {
+			return n, err
+		}
+	}
+	if root != d.latest() {
+		_, err = d.apply(Operation{Type: "reset", Hash: root}, false)
+	}
+	return n, err
+}
+
+func (d *DB) reindex(before, after string, ope Operation) (string, error) {
+	if len(d.state.Indexes) == 0 || reserved(ope.Key) || ope.Collection != "" {
+		return after, nil
+	}
+	if ope.Type == "batch" {
+		var err error
+		for _, e := range ope.Entries {
+			after, err = d.reindex(before, after, Operation{Type: "set", Key: e.Key, Hash: e.Hash})
+			if err != nil {
+				return "", err
+			}
+		}
+		return after, nil
+	}
+	if ope.Type != "set" && ope.Type != "del" {
+		return after, nil
+	}
+	old, _, err := d.lookup(before, ope.Key)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range d.indexNames() {
+		field := d.state.Indexes[name]
+		from, _, err := d.indexValue(old, field)
+		if err != nil {
+			return "", err
+		}
+		to := ""
+		if ope.Type == "set" {
+			to, _, err = d.indexValue(ope.Hash, field)
+			if err != nil {
+				return "", err
+			}
+		}
+		if from == to {
+			continue
+		}
+		if from != "" {
+			after, err = d.updateBucket(after, name, from, ope.Key, false)
+			if err != nil {
+				return "", err
+			}
+		}
+		if to != "" {
+			after, err = d.updateBucket(after, name, to, ope.Key, true)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return after, nil
+}
+
+func (d *DB) indexNames() []string {
+	names := make([]string, 0, len(d.state.Indexes))
+	for name := range d.state.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// indexValue reports values that are not JSON or lack the field as not
+// indexed, but returns errors reading the value.
+func (d *DB) indexValue(hash, field string) (string, bool, error) {
+	if hash == "" {
+		return "", false, nil
+	}
+	data, err := d.blob(hash)
+	if err != nil {
+		return "", false, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return "", false, nil
+	}
+	value, ok := lookupField(doc, field)
+	if !ok {
+		return "", false, nil
+	}
+	switch value.(type) {
+	case string, float64, bool:
+		canonical, err := json.Marshal(value)
+		if err != nil {
+			return "", false, err
+		}
+		return string(canonical), true, nil
+	}
+	return "", false, nil
+}
+
+func (d *DB) updateBucket(root, name, value, key string, add bool) (string, error) {
+	bucket, err := d.readBucket(root, name, value)
+	if err != nil {
+		return "", err
+	}
+	keys := []string{}
+	for _, k := range bucket.Keys {
+		if k != key {
+			keys = append(keys, k)
+		}
+	}
+	if add {
+		keys = append(keys, key)
+		sort.Strings(keys)
+	}
+	bucket.Keys = keys
+	return d.writeBucket(root, name, value, bucket)
+}
+
+func (d *DB) readBucket(root, name, value string) (indexBucket, error) {
+	bucket := indexBucket{Value: json.RawMessage(value)}
+	dir, err := d.indexDir(name)
+	if err != nil {
+		return bucket, err
+	}
+	file, err := d.keystore.Digest(name, value)
+	if err != nil {
+		return bucket, err
+	}
+	hash, ok, err := d.walk(root, indexRoot, dir, file)
+	if err != nil || !ok {
+		return bucket, err
+	}
+	data, err := d.blob(hash)
+	if err != nil {
+		return bucket, err
+	}
+	err = json.Unmarshal(data, &bucket)
+	return bucket, err
+}
+
+func (d *DB) writeBucket(root, name, value string, bucket indexBucket) (string, error) {
+	dir, err := d.indexDir(name)
+	if err != nil {
+		return "", err
+	}
+	file, err := d.keystore.Digest(name, value)
+	if err != nil {
+		return "", err
+	}
+	path := strings.Join([]string{indexRoot, dir, file}, "/")
+	if len(bucket.Keys) == 0 {
+		_, ok, err := d.walk(root, indexRoot, dir, file)
+		if err != nil || !ok {
+			return root, err
+		}
+		return d.client.ObjectPatchRmLink(root, path, RequestOptions{})
+	}
+
+	data, err := json.Marshal(bucket)
+	if err != nil {
+		return "", err
+	}
+	hash, err := d.upload(bytes.NewReader(data), metadata{codec: JSONCodec.Name()})
+	if err != nil {
+		return "", err
+	}
+	d.state.Objects = append(d.state.Objects, hash)
+	return d.client.ObjectPatchAddLink(root, path, hash, RequestOptions{"create": "true"})
+}
+
+func (d *DB) clearIndex(root, name string) (string, error) {
+	dir, err := d.indexDir(name)
+	if err != nil {
+		return "", err
+	}
+	_, ok, err := d.walk(root, indexRoot, dir)
+	if err != nil || !ok {
+		return root, err
+	}
+	return d.client.ObjectPatchRmLink(root, indexRoot+"/"+dir, RequestOptions{})
+}
+
+func (d *DB) indexDir(name string) (string, error) {
+	return d.keystore.Digest(name)
+}
+
+func (d *DB) indexObjects(hash string) ([]string, error) {
+	dirs, err := d.client.ObjectLinks(hash, RequestOptions{})
+	if err != nil {
+		return []string{}, err
+	}
+	hashes := []string{}
+	for _, dir := range dirs {
+		hashes = append(hashes, dir.Hash)
+		buckets, err := d.client.ObjectLinks(dir.Hash, RequestOptions{})
+		if err != nil {
+			return []string{}, err
+		}
+		for _, bucket := range buckets {
+			hashes = append(hashes, bucket.Hash)
+		}
+	}
+	return hashes, nil
+}
+
+func (d *DB) walk(root string, names ...string) (string, bool, error) {
+	hash := root
+	for _, name := range names {
+		next, ok, err := d.lookup(hash, name)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		hash = next
+	}
+	return hash, true, nil
+}
+
+func (d *DB) blob(hash string) ([]byte, error) {
+	plain, err := d.plain(hash)
+	if err != nil {
+		return []byte{}, err
+	}
+	m, value, err := unwrap(plain)
+	if err != nil {
+		return []byte{}, err
+	}
+	if m.layout == chunkedLayout {
+		return d.assemble(hash, value)
+	}
+	return value, nil
+}
+
+func lookupField(doc interface{}, field string) (interface{}, bool) {
+	for _, name := range strings.Split(field, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		doc, ok = m[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func within(value, min, max interface{}) bool {
+	if min != nil {
+		c, ok := compare(value, normalize(min))
+		if !ok || c < 0 {
+			return false
+		}
+	}
+	if max != nil {
+		c, ok := compare(value, normalize(max))
+		if !ok || c > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func normalize(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var n interface{}
+	if json.Unmarshal(data, &n) != nil {
+		return v
+	}
+	return n
+}
+
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
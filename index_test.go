@@ -0,0 +1,137 @@
+package kaleidoscope
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func testIndexedDB(url string) *DB {
+	db := testDB(url, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.Set("alice", `{"team":"red","age":30}`)
+	db.Set("bob", `{"team":"blue","age":25}`)
+	db.Set("carol", `{"team":"red","age":41}`)
+	db.Set("note", "not json")
+	return db
+}
+
+func TestDBDefineIndexAndQuery(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testIndexedDB(ipfs.URL)
+	n, err := db.DefineIndex("team", "team")
+	if err != nil {
+		t.Errorf("DefineIndex should not return error, but %s", err)
+	}
+	if n != 3 {
+		t.Errorf("DefineIndex should index existing JSON values (3), but %d", n)
+	}
+
+	keys, err := db.Query("team", "red")
+	if err != nil {
+		t.Errorf("Query should not return error, but %s", err)
+	}
+	if strings.Join(keys, ",") != "alice,carol" {
+		t.Errorf("Query should return keys with team=red, but %v", keys)
+	}
+
+	db.Set("alice", `{"team":"blue","age":30}`)
+	db.Del("carol")
+	keys, _ = db.Query("team", "red")
+	if len(keys) != 0 {
+		t.Errorf("Query should reflect Set and Del, but %v", keys)
+	}
+	keys, _ = db.Query("team", "blue")
+	if strings.Join(keys, ",") != "alice,bob" {
+		t.Errorf("Query should return keys with team=blue, but %v", keys)
+	}
+
+	stored, _ := db.Keys()
+	if strings.Join(stored, ",") != "alice,bob,note" {
+		t.Errorf("Keys should not list index sub-DAGs, but %v", stored)
+	}
+}
+
+func TestDBQueryRange(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testIndexedDB(ipfs.URL)
+	db.DefineIndex("age", "age")
+
+	keys, err := db.QueryRange("age", 26, nil)
+	if err != nil {
+		t.Errorf("QueryRange should not return error, but %s", err)
+	}
+	if strings.Join(keys, ",") != "alice,carol" {
+		t.Errorf("QueryRange should return keys with age>=26 in order, but %v", keys)
+	}
+	keys, _ = db.QueryRange("age", nil, 30)
+	if strings.Join(keys, ",") != "bob,alice" {
+		t.Errorf("QueryRange should return keys with age<=30 in order, but %v", keys)
+	}
+}
+
+func TestDBRebuildIndexAfterDrop(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testIndexedDB(ipfs.URL)
+	db.DefineIndex("team", "team")
+	err := db.DropIndex("team")
+	if err != nil {
+		t.Errorf("DropIndex should not return error, but %s", err)
+	}
+	if _, err := db.Query("team", "red"); err == nil {
+		t.Errorf("Query should fail for a dropped index")
+	}
+
+	db.state.Indexes["team"] = "team"
+	n, err := db.RebuildIndex("team")
+	if err != nil || n != 3 {
+		t.Errorf("RebuildIndex should index existing values, but %d %v", n, err)
+	}
+	keys, _ := db.Query("team", "red")
+	if strings.Join(keys, ",") != "alice,carol" {
+		t.Errorf("Query should use rebuilt index, but %v", keys)
+	}
+}
+
+func TestDBIndexAbortsOnReadError(t *testing.T) {
+	dag, unused := newTestDAG()
+	unused.Close()
+
+	var failing int32
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 && r.URL.Path == "/api/v0/cat" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"Message":"merkledag: not found","Code":0}`))
+			return
+		}
+		dag.ServeHTTP(w, r)
+	}))
+	defer ipfs.Close()
+
+	db := testIndexedDB(ipfs.URL)
+	db.DefineIndex("team", "team")
+
+	atomic.StoreInt32(&failing, 1)
+	_, err := db.Set("alice", `{"team":"blue","age":30}`)
+	if err == nil {
+		t.Errorf("Set should fail when the indexed value can not be read")
+	}
+	if _, err := db.RebuildIndex("team"); err == nil {
+		t.Errorf("RebuildIndex should fail when a value can not be read")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	keys, _ := db.Query("team", "red")
+	if strings.Join(keys, ",") != "alice,carol" {
+		t.Errorf("Query should keep the index of a failed Set, but %v", keys)
+	}
+}
@@ -0,0 +1,85 @@
+package kaleidoscope
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Sources of the head a database was opened at, see Recovery.Source.
+const (
+	SourceIPNS  = "ipns"
+	SourceLocal = "local"
+)
+
+// NameOptions configures how databases resolve and publish their IPNS
+// record. The zero value keeps the daemon defaults and resolves without
+// the cache.
+type NameOptions struct {
+	// Lifetime and TTL of published records.
+	Lifetime time.Duration
+	TTL      time.Duration
+	// AllowOffline publishes even when the node is not connected to peers.
+	AllowOffline bool
+	// ResolveTimeout bounds the resolution of a database.
+	ResolveTimeout time.Duration
+	// Recursive resolves until the result is not an IPNS name.
+	Recursive bool
+	// Cache allows the daemon to answer from its name cache.
+	Cache bool
+	// Fallback opens the database at the locally persisted head when the
+	// resolution times out.
+	Fallback bool
+}
+
+func (o NameOptions) publish(key string) RequestOptions {
+	opts := RequestOptions{"key": key}
+	if o.Lifetime > 0 {
+		opts["lifetime"] = o.Lifetime.String()
+	}
+	if o.TTL > 0 {
+		opts["ttl"] = o.TTL.String()
+	}
+	if o.AllowOffline {
+		opts["allow-offline"] = "true"
+	}
+	return opts
+}
+
+func (o NameOptions) resolve() RequestOptions {
+	opts := RequestOptions{}
+	if !o.Cache {
+		opts["nocache"] = "true"
+	}
+	if o.Recursive {
+		opts["recursive"] = "true"
+	}
+	if o.ResolveTimeout > 0 {
+		opts["dht-timeout"] = o.ResolveTimeout.String()
+		opts["timeout"] = o.ResolveTimeout.String()
+	}
+	return opts
+}
+
+// timeoutGrace lets the daemon report its own timeout before the client
+// gives up on a request sent with the timeout option.
+const timeoutGrace = time.Second
+
+func requestTimeout(opts map[string]string) time.Duration {
+	d, err := time.ParseDuration(opts["timeout"])
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d + timeoutGrace
+}
+
+// timedOut reports whether err comes from a request or a daemon side
+// operation that ran out of time.
+func timedOut(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ierr *Error
+	return errors.As(err, &ierr) && strings.Contains(ierr.Message, context.DeadlineExceeded.Error())
+}
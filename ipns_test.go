@@ -0,0 +1,122 @@
+package kaleidoscope
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDBResolveNameOptions(t *testing.T) {
+	var query url.Values
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprintln(w, `{"Path":"/ipfs/QmPublished"}`)
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "")
+	db.keystore = testKeystore()
+	err := db.resolve()
+	if err != nil {
+		t.Errorf("resolve should not return error, but %s", err)
+	}
+	if query.Get("nocache") != "true" || query.Get("recursive") != "" || query.Get("timeout") != "" {
+		t.Errorf("resolve should bypass the cache with daemon defaults, but %s", query.Encode())
+	}
+
+	db.SetNameOptions(NameOptions{ResolveTimeout: 5 * time.Second, Recursive: true, Cache: true})
+	err = db.resolve()
+	if err != nil {
+		t.Errorf("resolve should not return error, but %s", err)
+	}
+	if query.Get("nocache") != "" || query.Get("recursive") != "true" ||
+		query.Get("timeout") != "5s" || query.Get("dht-timeout") != "5s" {
+		t.Errorf("resolve should pass the name options, but %s", query.Encode())
+	}
+	if r := db.Recovery(); r.Source != SourceIPNS || r.Local() {
+		t.Errorf("resolve should report the IPNS record as the source, but %+v", r)
+	}
+}
+
+func TestDBResolveFallback(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := r.URL.Query()["arg"]
+		switch r.URL.Path {
+		case "/api/v0/name/resolve":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"Message":"context deadline exceeded","Code":0}`)
+		case "/api/v0/object/patch/add-link":
+			fmt.Fprintf(w, `{"Hash":"%s+%s"}`, args[0], args[1])
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "")
+	db.keystore = testKeystore()
+	db.state.Published = "QmLocal"
+	db.state.WAL = []Operation{{Type: "set", Database: "dbname", Key: "a", Hash: "QmA"}}
+
+	db.SetNameOptions(NameOptions{ResolveTimeout: time.Second})
+	if err := db.resolve(); !timedOut(err) {
+		t.Errorf("resolve should return the timeout without fallback, but %v", err)
+	}
+
+	db.SetNameOptions(NameOptions{ResolveTimeout: time.Second, Fallback: true})
+	err := db.resolve()
+	if err != nil {
+		t.Errorf("resolve should fall back to the local head, but %s", err)
+	}
+	r := db.Recovery()
+	if !r.Local() || r.Published != "QmLocal" || db.Head() != "QmLocal+a" {
+		t.Errorf("resolve should replay the WAL onto the local head, but %+v", r)
+	}
+}
+
+func TestDBResolveClientTimeout(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "")
+	db.keystore = testKeystore()
+	db.state.Published = "QmLocal"
+	db.SetNameOptions(NameOptions{ResolveTimeout: time.Millisecond, Fallback: true})
+	err := db.resolve()
+	if err != nil {
+		t.Errorf("resolve should fall back when the daemon does not answer in time, but %s", err)
+	}
+	if !db.Recovery().Local() || db.Head() != "QmLocal" {
+		t.Errorf("resolve should use the local head, but %+v", db.Recovery())
+	}
+}
+
+func TestDBSaveNameOptions(t *testing.T) {
+	var query url.Values
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/name/publish":
+			query = r.URL.Query()
+			fmt.Fprintln(w, `{"Name":"QmSomeName","Value":"/ipfs/QmRoot"}`)
+		case "/api/v0/pin/add":
+			fmt.Fprintln(w, `{"Pins":["QmRoot"]}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+	db.SetNameOptions(NameOptions{Lifetime: 48 * time.Hour, TTL: time.Minute, AllowOffline: true})
+	err := db.Save()
+	if err != nil {
+		t.Errorf("Save should not return error, but %s", err)
+	}
+	if query.Get("key") != "dbname" || query.Get("lifetime") != "48h0m0s" ||
+		query.Get("ttl") != "1m0s" || query.Get("allow-offline") != "true" {
+		t.Errorf("Save should publish with the name options, but %s", query.Encode())
+	}
+}
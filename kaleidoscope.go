@@ -1,212 +1,295 @@
 package kaleidoscope
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
+	"io/fs"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
 
 type Kaleidoscope struct {
-	dbname   string
-	head     string
-	client   Client
-	keystore Keystore
-	stream   Stream
-	mu       sync.Mutex
+	client      Client
+	dbs         map[string]*DB
+	pending     map[string]*pending
+	current     *DB
+	cache       *Cache
+	names       NameOptions
+	persistence bool
+	mu          sync.Mutex
 }
 
-func New() (Kaleidoscope, error) {
+func New() (*Kaleidoscope, error) {
 	client, err := NewClient()
 	if err != nil {
-		return Kaleidoscope{}, err
+		return nil, err
 	}
-	return Kaleidoscope{
-		client:   client,
-		keystore: NewKeyStore(),
-	}, nil
+	return newKaleidoscope(client), nil
 }
 
-func (k *Kaleidoscope) Create(dbname string, size int) (string, error) {
-	err := k.client.KeyGen(dbname, RequestOptions{
-		"type": "rsa",
-		"size": strconv.Itoa(size),
-	})
-	if err != nil {
-		return "", err
-	}
-	err = k.keystore.Load(dbname)
-	if err != nil {
-		return "", err
+func newKaleidoscope(client Client) *Kaleidoscope {
+	return &Kaleidoscope{
+		client:      client,
+		dbs:         map[string]*DB{},
+		pending:     map[string]*pending{},
+		persistence: true,
 	}
+}
 
-	return k.set(dbname, EmptyDirMultiHash, "__database_name", dbname)
+func (k *Kaleidoscope) Open(dbname string) (*DB, error) {
+	return k.open(dbname)
 }
 
-func (k *Kaleidoscope) Use(dbname string) error {
-	err := k.keystore.Load(dbname)
-	if err != nil {
-		return err
-	}
-	ipns, err := k.keystore.PeerID()
-	if err != nil {
+func (k *Kaleidoscope) open(dbname string) (*DB, error) {
+	return k.register(dbname, false, func(db *DB) error {
+		err := db.load()
+		if errors.Is(err, fs.ErrNotExist) {
+			return &DatabaseError{Database: dbname, Err: err}
+		}
+		if err != nil {
+			return err
+		}
+		return db.resolve()
+	})
+}
+
+func (k *Kaleidoscope) CreateDB(dbname string, size int) (*DB, error) {
+	return k.create(dbname, size)
+}
+
+func (k *Kaleidoscope) create(dbname string, size int) (*DB, error) {
+	return k.register(dbname, true, func(db *DB) error {
+		err := k.client.KeyGen(dbname, RequestOptions{
+			"type": "rsa",
+			"size": strconv.Itoa(size),
+		})
+		if err != nil {
+			return err
+		}
+		err = db.load()
+		if err != nil {
+			return err
+		}
+		db.state.Head = ""
+		db.state.Published = ""
+		err = db.state.TruncateWAL()
+		if err != nil {
+			return err
+		}
+		db.use(EmptyDirMultiHash)
+		_, err = db.set("__database_name", dbname)
 		return err
+	})
+}
+
+// pending is a database being opened or created.
+type pending struct {
+	done chan struct{}
+	db   *DB
+	err  error
+}
+
+// register runs init for a new database outside of k.mu, so resolving or
+// creating one database does not block the others. Concurrent opens of the
+// same name wait for the first one; creating a name that is open or being
+// opened is a conflict.
+func (k *Kaleidoscope) register(dbname string, create bool, init func(*DB) error) (*DB, error) {
+	k.mu.Lock()
+	db, opened := k.dbs[dbname]
+	p, inflight := k.pending[dbname]
+	switch {
+	case create && (opened || inflight):
+		k.mu.Unlock()
+		return nil, &ExistsError{Database: dbname}
+	case opened:
+		k.mu.Unlock()
+		return db, nil
+	case inflight:
+		k.mu.Unlock()
+		<-p.done
+		return p.db, p.err
 	}
-	head, err := k.client.NameResolve(ipns, RequestOptions{"nocache": "true"})
-	if err != nil {
-		return err
+	p = &pending{done: make(chan struct{})}
+	k.pending[dbname] = p
+	db = k.newDB(dbname)
+	k.mu.Unlock()
+
+	err := init(db)
+
+	k.mu.Lock()
+	delete(k.pending, dbname)
+	if err == nil {
+		// The settings may have changed while the database was opened.
+		db.SetCache(k.cache)
+		db.SetNameOptions(k.names)
+		k.dbs[dbname] = db
+		p.db = db
 	}
-	err = k.keystore.Load(dbname)
-	if err != nil {
-		return err
+	p.err = err
+	k.mu.Unlock()
+	close(p.done)
+	return p.db, p.err
+}
+
+func (k *Kaleidoscope) Lookup(dbname string) (*DB, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	db, ok := k.dbs[dbname]
+	return db, ok
+}
+
+func (k *Kaleidoscope) Opened() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	names := make([]string, 0, len(k.dbs))
+	for name := range k.dbs {
+		names = append(names, name)
 	}
-	k.use(dbname, head)
-	return nil
+	sort.Strings(names)
+	return names
 }
 
-func (k *Kaleidoscope) Set(key, value string) (string, error) {
+func (k *Kaleidoscope) Close(dbname string) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	return k.set(k.dbname, k.latest(), key, value)
+	k.close(dbname)
 }
 
-func (k Kaleidoscope) Get(key string) ([]byte, []byte, error) {
-	enc, err := k.client.Cat(k.latest()+"/"+key+"/value", RequestOptions{})
-	if err != nil {
-		return []byte{}, []byte{}, err
+func (k *Kaleidoscope) close(dbname string) {
+	db, ok := k.dbs[dbname]
+	if !ok {
+		return
 	}
-	plain, err := k.keystore.Decrypt(enc)
-	if err != nil {
-		return []byte{}, []byte{}, err
+	db.StopSync()
+	db.StopSweeper()
+	delete(k.dbs, dbname)
+	if k.current == db {
+		k.current = nil
 	}
-	return plain[0:10], plain[11:], nil
 }
 
-func (k *Kaleidoscope) Del(key string) (string, error) {
+func (k *Kaleidoscope) SetCache(c *Cache) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	return k.del(key, true)
+	k.cache = c
+	for _, db := range k.dbs {
+		db.SetCache(c)
+	}
 }
 
-func (k *Kaleidoscope) del(key string, pub bool) (string, error) {
-	hash, err := k.client.ObjectPatchRmLink(k.latest(), key, RequestOptions{})
+func (k *Kaleidoscope) SetNameOptions(o NameOptions) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.names = o
+	for _, db := range k.dbs {
+		db.SetNameOptions(o)
+	}
+}
+
+func (k *Kaleidoscope) Create(dbname string, size int) (string, error) {
+	db, err := k.create(dbname, size)
 	if err != nil {
 		return "", err
 	}
-	if pub && k.stream.IsRunning() {
-		ope := Operation{
-			Type:     "del",
-			Database: k.dbname,
-			Key:      key,
-			Hash:     "",
-		}
-		json, err := json.Marshal(ope)
-		if err != nil {
-			return "", err
-		}
-		k.client.PubSubPub(k.dbname, string(json), RequestOptions{})
+	k.mu.Lock()
+	k.current = db
+	k.mu.Unlock()
+	return db.Head(), nil
+}
+
+func (k *Kaleidoscope) Use(dbname string) error {
+	db, err := k.open(dbname)
+	if err != nil {
+		return err
 	}
-	k.use(k.dbname, hash)
-	return hash, nil
+	k.mu.Lock()
+	k.current = db
+	k.mu.Unlock()
+	return nil
 }
 
-func (k *Kaleidoscope) Save() error {
+func (k *Kaleidoscope) Current() (*DB, error) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	_, _, err := k.client.NamePublish(k.head, RequestOptions{"key": k.dbname})
-	return err
+	if k.current == nil {
+		return nil, &DatabaseError{}
+	}
+	return k.current, nil
 }
 
-func (k *Kaleidoscope) StartSync() error {
-	stream, err := k.client.PubSubSub(k.dbname, RequestOptions{"discover": "true"})
+func (k *Kaleidoscope) Database() string {
+	db, err := k.Current()
 	if err != nil {
-		return err
+		return ""
 	}
-	k.stream = stream
-	go func() {
-		for data := range k.stream.Data {
-			var ope Operation
-			err := json.NewDecoder(strings.NewReader(data)).Decode(&ope)
-			if err != nil {
-				continue
-			}
-			if ope.Database != k.dbname {
-				continue
-			}
-			if typ := strings.ToLower(ope.Type); typ == "set" {
-				func() {
-					k.mu.Lock()
-					defer k.mu.Unlock()
-					k.setHash(k.dbname, k.latest(), ope.Key, ope.Hash, false)
-				}()
-			} else if typ == "del" {
-				func() {
-					k.mu.Lock()
-					defer k.mu.Unlock()
-					k.del(ope.Key, false)
-				}()
-			}
-		}
-	}()
-	return nil
+	return db.Name()
 }
 
-func (k *Kaleidoscope) StopSync() {
-	k.stream.Close()
+func (k *Kaleidoscope) Head() string {
+	db, err := k.Current()
+	if err != nil {
+		return ""
+	}
+	return db.Head()
 }
 
-type Operation struct {
-	Type     string
-	Database string
-	Key      string
-	Hash     string
+func (k *Kaleidoscope) Set(key, value string) (string, error) {
+	db, err := k.Current()
+	if err != nil {
+		return "", err
+	}
+	return db.Set(key, value)
 }
 
-func (k *Kaleidoscope) set(dbname, root, key, value string) (string, error) {
-	enc, err := k.keystore.EncryptString(wrapWithMetadata(value))
+func (k *Kaleidoscope) Get(key string) ([]byte, []byte, error) {
+	db, err := k.Current()
 	if err != nil {
-		return "", err
+		return []byte{}, []byte{}, err
 	}
-	hash, err := k.client.Add("value", bytes.NewReader(enc),
-		RequestOptions{"wrap-with-directory": "true"})
+	return db.Get(key)
+}
+
+func (k *Kaleidoscope) Del(key string) (string, error) {
+	db, err := k.Current()
 	if err != nil {
 		return "", err
 	}
-	return k.setHash(dbname, root, key, hash, true)
+	return db.Del(key)
 }
 
-func (k *Kaleidoscope) setHash(dbname, root, key, hash string, pub bool) (string, error) {
-	dbhash, err := k.client.ObjectPatchAddLink(root, key, hash, RequestOptions{})
+func (k *Kaleidoscope) Save() error {
+	db, err := k.Current()
 	if err != nil {
-		return "", err
+		return err
 	}
+	return db.Save()
+}
 
-	k.use(dbname, dbhash)
-	if pub && k.stream.IsRunning() {
-		ope := Operation{
-			Type:     "set",
-			Database: k.dbname,
-			Key:      key,
-			Hash:     hash,
-		}
-		json, err := json.Marshal(ope)
-		if err != nil {
-			return "", err
-		}
-		k.client.PubSubPub(k.dbname, string(json), RequestOptions{})
+func (k *Kaleidoscope) StartSync() error {
+	db, err := k.Current()
+	if err != nil {
+		return err
 	}
-	return dbhash, err
+	return db.StartSync()
 }
 
-func (k *Kaleidoscope) use(dbname, head string) {
-	k.dbname = dbname
-	k.head = head
+func (k *Kaleidoscope) StopSync() {
+	db, err := k.Current()
+	if err != nil {
+		return
+	}
+	db.StopSync()
 }
 
-func (k Kaleidoscope) latest() string {
-	return k.head
+func (k *Kaleidoscope) newDB(dbname string) *DB {
+	db := newDB(k.client, dbname)
+	db.cache = k.cache
+	db.names = k.names
+	// Use only for testing.
+	db.keystore.persistence = k.persistence
+	db.state.persistence = k.persistence
+	return db
 }
 
 func wrapWithMetadata(value string) string {
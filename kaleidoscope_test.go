@@ -1,11 +1,15 @@
 package kaleidoscope
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestKaleidoScopeCreateAndSave(t *testing.T) {
@@ -19,7 +23,9 @@ func TestKaleidoScopeCreateAndSave(t *testing.T) {
 	resForAddLink := `{"Hash":"%s"}`
 
 	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/api/v0/key/gen" {
+		if r.URL.Path == "/api/v0/id" {
+			fmt.Fprintln(w, `{"ID":"QmTestPeer"}`)
+		} else if r.URL.Path == "/api/v0/key/gen" {
 			fmt.Fprintln(w, `{"Name":"some_key","Id":"QmSomePeerID"}`)
 		} else if r.URL.Path == "/api/v0/add" {
 			fmt.Fprintln(w, fmt.Sprintf(resForAdd, expectForAdd))
@@ -27,12 +33,17 @@ func TestKaleidoScopeCreateAndSave(t *testing.T) {
 			fmt.Fprintln(w, fmt.Sprintf(resForAddLink, expectForAddLink))
 		} else if r.URL.Path == "/api/v0/name/publish" {
 			fmt.Fprintln(w, `{"Name":"QmSomeName","Value":"/ipfs/QmSomeValue"}`)
+		} else if r.URL.Path == "/api/v0/pin/add" {
+			fmt.Fprintln(w, fmt.Sprintf(`{"Pins":["%s"]}`, r.URL.Query().Get("arg")))
+		} else if r.URL.Path == "/api/v0/object/links" {
+			fmt.Fprintln(w, fmt.Sprintf(`{"Hash":"%s","Links":[{"Name":"__database_name","Hash":"%s"}]}`, expectForAddLink, expectForAdd))
+		} else if r.URL.Path == "/api/v0/pin/rm" {
+			fmt.Fprintln(w, fmt.Sprintf(`{"Pins":["%s"]}`, r.URL.Query().Get("arg")))
 		}
 	}))
 	defer ipfs.Close()
 
 	kes := testKaleidoScope(ipfs.URL)
-	kes.keystore.persistence = false
 	dbhash, err := kes.Create(dbname, 2048)
 
 	if err != nil {
@@ -42,18 +53,204 @@ func TestKaleidoScopeCreateAndSave(t *testing.T) {
 		t.Errorf("Create should return database's hash (%s), but %s", expectForAddLink, dbhash)
 	}
 
-	if kes.dbname != dbname {
-		t.Errorf("Create should set current dbname (%s), but %s", dbname, kes.dbname)
+	if kes.Database() != dbname {
+		t.Errorf("Create should set current dbname (%s), but %s", dbname, kes.Database())
 	}
 
-	if kes.head != expectForAddLink {
-		t.Errorf("Create should set current hash (%s), but %s", expectForAddLink, kes.head)
+	if kes.Head() != expectForAddLink {
+		t.Errorf("Create should set current hash (%s), but %s", expectForAddLink, kes.Head())
 	}
 
 	err = kes.Save()
 	if err != nil {
 		t.Errorf("Save should not return error, but %s", err)
 	}
+	db, _ := kes.Lookup(dbname)
+	if len(db.state.Pins) != 1 || db.state.Pins[0] != expectForAddLink {
+		t.Errorf("Save should pin current hash (%s), but %v", expectForAddLink, db.state.Pins)
+	}
+	if len(db.state.Objects) != 0 {
+		t.Errorf("Save should release values held by the pinned root, but %v", db.state.Objects)
+	}
+}
+
+func TestKaleidoScopeOpenConcurrently(t *testing.T) {
+	var n int64
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/id":
+			fmt.Fprintln(w, `{"ID":"QmTestPeer"}`)
+		case "/api/v0/name/resolve":
+			fmt.Fprintln(w, `{"Path":"/ipfs/QmRoot"}`)
+		case "/api/v0/add":
+			fmt.Fprintln(w, `{"Name":"","Hash":"QmValue","Size":"67"}`)
+		case "/api/v0/object/patch/add-link", "/api/v0/object/patch/rm-link":
+			fmt.Fprintln(w, fmt.Sprintf(`{"Hash":"QmRoot%d"}`, atomic.AddInt64(&n, 1)))
+		case "/api/v0/object/links":
+			fmt.Fprintln(w, `{"Hash":"QmRoot","Links":[{"Name":"a","Hash":"QmValue"}]}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	kes := testKaleidoScope(ipfs.URL)
+	var wg sync.WaitGroup
+	for _, dbname := range []string{"db1", "db2", "db1", "db2"} {
+		wg.Add(1)
+		go func(dbname string) {
+			defer wg.Done()
+			db, err := kes.Open(dbname)
+			if err != nil {
+				t.Errorf("Open should not return error, but %s", err)
+				return
+			}
+			events, cancel := db.Watch()
+			defer cancel()
+			for i := 0; i < 5; i++ {
+				db.Set("a", "value")
+				db.Keys()
+				db.Del("a")
+				db.Head()
+			}
+			<-events
+		}(dbname)
+	}
+	wg.Wait()
+
+	if opened := strings.Join(kes.Opened(), ","); opened != "db1,db2" {
+		t.Errorf("Open should register each database once, but %s", opened)
+	}
+	if n != 40 {
+		t.Errorf("Open should share handles between callers, expected 40 writes but %d", n)
+	}
+
+	_, err := kes.CreateDB("db1", 2048)
+	if !errors.Is(err, ErrExists) || !errors.Is(err, ErrConflict) || err.Error() != "Database db1 already exists" {
+		t.Errorf("CreateDB should return ErrExists for an opened database, but %v", err)
+	}
+}
+
+func TestKaleidoScopeOpenDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	var resolved int64
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/name/resolve" {
+			if atomic.AddInt64(&resolved, 1) == 1 {
+				<-release
+			}
+			fmt.Fprintln(w, `{"Path":"/ipfs/QmRoot"}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	kes := testKaleidoScope(ipfs.URL)
+	opened := make(chan *DB, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			db, _ := kes.Open("slow")
+			opened <- db
+		}()
+		for atomic.LoadInt64(&resolved) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	db, err := kes.Open("fast")
+	if err != nil {
+		t.Fatalf("Open should not return error, but %s", err)
+	}
+	if names := strings.Join(kes.Opened(), ","); names != "fast" {
+		t.Errorf("Opened should only list registered databases while another one resolves, but %s", names)
+	}
+	if _, ok := kes.Lookup(db.Name()); !ok {
+		t.Errorf("Lookup should not wait for another database to resolve")
+	}
+
+	close(release)
+	first, second := <-opened, <-opened
+	if first == nil || first != second {
+		t.Errorf("Open should share the handle with callers waiting for it, but %p and %p", first, second)
+	}
+	if resolved != 2 {
+		t.Errorf("Open should resolve each database once, but %d", resolved)
+	}
+}
+
+func TestKaleidoScopeSaveUnpinsSupersededRoots(t *testing.T) {
+	var unpinned []string
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arg := r.URL.Query().Get("arg")
+		if r.URL.Path == "/api/v0/name/publish" {
+			fmt.Fprintln(w, `{"Name":"QmSomeName","Value":"/ipfs/QmSomeValue"}`)
+		} else if r.URL.Path == "/api/v0/pin/add" {
+			fmt.Fprintln(w, fmt.Sprintf(`{"Pins":["%s"]}`, arg))
+		} else if r.URL.Path == "/api/v0/pin/rm" {
+			unpinned = append(unpinned, arg)
+			fmt.Fprintln(w, fmt.Sprintf(`{"Pins":["%s"]}`, arg))
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "")
+	db.SetRetention(2)
+	for _, head := range []string{"QmRoot1", "QmRoot2", "QmRoot3"} {
+		db.use(head)
+		err := db.Save()
+		if err != nil {
+			t.Errorf("Save should not return error, but %s", err)
+		}
+	}
+
+	if len(unpinned) != 1 || unpinned[0] != "QmRoot1" {
+		t.Errorf("Save should unpin superseded root (QmRoot1), but %v", unpinned)
+	}
+	if len(db.state.Pins) != 2 || db.state.Pins[1] != "QmRoot3" {
+		t.Errorf("Save should keep retained roots pinned, but %v", db.state.Pins)
+	}
+}
+
+func TestKaleidoScopePinStatus(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/pin/ls" {
+			fmt.Fprintln(w, `{"Keys":{"QmRoot":{"Type":"recursive"}}}`)
+		} else if r.URL.Path == "/api/v0/object/links" {
+			switch r.URL.Query().Get("arg") {
+			case "QmRoot":
+				fmt.Fprintln(w, `{"Hash":"QmRoot","Links":[{"Name":"__collections","Hash":"QmCollections"},{"Name":"a","Hash":"QmA"},{"Name":"b","Hash":"QmB"}]}`)
+			case "QmCollections":
+				fmt.Fprintln(w, `{"Hash":"QmCollections","Links":[{"Name":"users","Hash":"QmUsers"}]}`)
+			case "QmUsers":
+				fmt.Fprintln(w, `{"Hash":"QmUsers","Links":[{"Name":"alice","Hash":"QmAlice"}]}`)
+			}
+		} else if r.URL.Path == "/api/v0/block/stat" {
+			if arg := r.URL.Query().Get("arg"); arg == "QmB/value" || arg == "QmAlice/value" {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, "blockservice: key not found")
+				return
+			}
+			fmt.Fprintln(w, `{"Key":"QmValue","Size":10}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	status, err := db.PinStatus()
+
+	if err != nil {
+		t.Errorf("PinStatus should not return error, but %s", err)
+	}
+	if !status.Pinned {
+		t.Errorf("PinStatus should report pinned root")
+	}
+	if len(status.Values) != 3 || !status.Values[0].Available || status.Values[1].Available {
+		t.Errorf("PinStatus should report availability of each value, but %v", status.Values)
+	}
+	if v := status.Values[2]; v.Collection != "users" || v.Key != "alice" || v.Available {
+		t.Errorf("PinStatus should report values of collections, but %+v", v)
+	}
+	if status.Healthy() {
+		t.Errorf("PinStatus should not be healthy when a value is missing")
+	}
 }
 
 func TestKaleidoScopeGet(t *testing.T) {
@@ -68,10 +265,9 @@ func TestKaleidoScopeGet(t *testing.T) {
 	}))
 	defer ipfs.Close()
 
-	kes := testKaleidoScope(ipfs.URL)
-	kes.keystore = keystore
-	kes.Use("dummy")
-	meta, value, err := kes.Get("some_key")
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = keystore
+	meta, value, err := db.Get("some_key")
 
 	if err != nil {
 		t.Errorf("Get should not return error, but %s", err)
@@ -87,8 +283,347 @@ func TestKaleidoScopeGet(t *testing.T) {
 	}
 }
 
-func testKaleidoScope(url string) Kaleidoscope {
-	kes, _ := New()
-	kes.client = testClient(url)
+func TestKaleidoScopeSetValuesBeyondRSABudget(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	for _, size := range []int{180, 200, 240, 1000} {
+		value := strings.Repeat("x", size)
+		_, err := db.Set("key", value)
+		if err != nil {
+			t.Errorf("Set should store %d bytes, but %s", size, err)
+			continue
+		}
+		_, got, err := db.Get("key")
+		if err != nil || string(got) != value {
+			t.Errorf("Get should return %d bytes, but %d (%v)", size, len(got), err)
+		}
+	}
+	err := db.Each(func(r Record) error {
+		if r.Writer != testPeerID {
+			t.Errorf("Set should record the writer (%s), but %s", testPeerID, r.Writer)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Each should not return error, but %s", err)
+	}
+}
+
+func TestKaleidoScopeSetWriterError(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"Message":"some failure","Code":0}`)
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	_, err := db.Set("key", "value")
+	if err == nil || !strings.Contains(err.Error(), "some failure") {
+		t.Errorf("Set should return the error of the peer lookup, but %v", err)
+	}
+}
+
+func TestKaleidoScopeGetWithCache(t *testing.T) {
+	keystore := testKeystore()
+	enc, _ := keystore.EncryptString(wrapWithMetadata("Some value"))
+
+	requests := map[string]int{}
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if r.URL.Path == "/api/v0/object/stat" {
+			fmt.Fprintln(w, `{"Hash":"QmValue","NumLinks":1}`)
+		} else {
+			w.Write(enc)
+		}
+	}))
+	defer ipfs.Close()
+
+	for _, plaintext := range []bool{true, false} {
+		requests = map[string]int{}
+		db := testDB(ipfs.URL, "QmRoot")
+		db.keystore = keystore
+		cache := NewCache(1 << 20)
+		db.SetCache(cache)
+		db.SetPlaintextCache(plaintext)
+
+		for i := 0; i < 3; i++ {
+			_, value, err := db.Get("some_key")
+			if err != nil {
+				t.Errorf("Get should not return error, but %s", err)
+			}
+			if string(value) != "Some value" {
+				t.Errorf("Get should return value (Some value), but %s", string(value))
+			}
+		}
+
+		if requests["/api/v0/cat"] != 1 || requests["/api/v0/object/stat"] != 1 || requests["/api/v0/object/links"] != 0 {
+			t.Errorf("Get should resolve only the key and read through cache, but %v", requests)
+		}
+		_, cached := cache.Get("plain:QmValue")
+		if cached != plaintext {
+			t.Errorf("Get should cache plaintext only when enabled (%t)", plaintext)
+		}
+	}
+}
+
+func TestKaleidoScopeGetWithCacheReturnsCopies(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.SetCache(NewCache(1 << 20))
+	db.Set("key", "value")
+
+	for i := 0; i < 3; i++ {
+		_, value, err := db.Get("key")
+		if err != nil || string(value) != "value" {
+			t.Fatalf("Get should return the value unchanged by earlier callers, but %q (%v)", value, err)
+		}
+		value[0] = 'X'
+		v, _ := db.Value("key")
+		if string(v.Data) != "value" {
+			t.Fatalf("Value should return the value unchanged by earlier callers, but %q", v.Data)
+		}
+		v.Data[0] = 'Y'
+	}
+}
+
+func TestKaleidoScopeCompact(t *testing.T) {
+	var unpinned []string
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arg := r.URL.Query().Get("arg")
+		if r.URL.Path == "/api/v0/object/links" {
+			if arg == "QmRoot3" {
+				fmt.Fprintln(w, `{"Hash":"QmRoot3","Links":[{"Name":"a","Hash":"QmValueA2"}]}`)
+			} else {
+				fmt.Fprintln(w, `{"Hash":"QmRoot2","Links":[{"Name":"a","Hash":"QmValueA1"}]}`)
+			}
+		} else if r.URL.Path == "/api/v0/object/stat" {
+			fmt.Fprintln(w, fmt.Sprintf(`{"Hash":"%s","NumLinks":1,"BlockSize":50,"CumulativeSize":120}`, arg))
+		} else if r.URL.Path == "/api/v0/pin/rm" {
+			unpinned = append(unpinned, arg)
+			fmt.Fprintln(w, fmt.Sprintf(`{"Pins":["%s"]}`, arg))
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot3")
+	db.state.Pins = []string{"QmRoot1", "QmRoot2", "QmRoot3"}
+	db.state.Objects = []string{"QmValueA0", "QmValueA1", "QmValueA2"}
+
+	report, err := db.Compact(1)
+
+	if err != nil {
+		t.Errorf("Compact should not return error, but %s", err)
+	}
+	if strings.Join(unpinned, ",") != "QmRoot1,QmValueA0" {
+		t.Errorf("Compact should unpin unreachable objects, but %v", unpinned)
+	}
+	if report.Blocks != 3 || report.Bytes != 170 {
+		t.Errorf("Compact should report reclaimed blocks (3) and bytes (170), but %d and %d",
+			report.Blocks, report.Bytes)
+	}
+	if strings.Join(db.state.Objects, ",") != "QmValueA1,QmValueA2" {
+		t.Errorf("Compact should keep reachable objects, but %v", db.state.Objects)
+	}
+}
+
+func TestKaleidoScopeKeys(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"Hash":"QmRoot","Links":[{"Name":"a","Hash":"QmA"},{"Name":"b","Hash":"QmB"}]}`)
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	keys, err := db.Keys()
+
+	if err != nil {
+		t.Errorf("Keys should not return error, but %s", err)
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("Keys should return link names (a,b), but %v", keys)
+	}
+}
+
+func TestKaleidoScopeWatch(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"Hash":"QmNewRoot"}`)
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	events, cancel := db.Watch()
+
+	db.Del("some_key")
+	ev := <-events
+	if ev.Type != "del" || ev.Key != "some_key" || ev.Head != "QmNewRoot" {
+		t.Errorf("Watch should receive del event, but %v", ev)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("Watch should close events after cancel")
+	}
+}
+
+func TestKaleidoScopeSetBatch(t *testing.T) {
+	dag, unused := newTestDAG()
+	unused.Close()
+	var mu sync.Mutex
+	calls := map[string]int{}
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[strings.TrimPrefix(r.URL.Path, "/api/v0/")]++
+		mu.Unlock()
+		dag.ServeHTTP(w, r)
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	db.peer = "QmPeer"
+	db.Set("c", "3")
+	calls = map[string]int{}
+	wal := len(db.state.WAL)
+	hash, err := db.SetBatch([]Record{
+		{Key: "a", Value: "1", Timestamp: "1500000000", Writer: "QmOther"},
+		{Key: "b", Value: "2"},
+		{Key: "a", Value: "4", Timestamp: "1500000001", Writer: "QmOther"},
+	})
+
+	if err != nil {
+		t.Fatalf("SetBatch should not return error, but %s", err)
+	}
+	if hash != db.Head() {
+		t.Errorf("SetBatch should return the new head, but %s", hash)
+	}
+	if calls["object/put"] != 1 || calls["object/patch/add-link"] != 0 || calls["add"] != 3 {
+		t.Errorf("SetBatch should upload every value and write the directory once, but %v", calls)
+	}
+	if len(db.state.WAL) != wal+1 || db.state.WAL[wal].Type != "batch" || len(db.state.WAL[wal].Entries) != 2 {
+		t.Errorf("SetBatch should log one batch operation, but %+v", db.state.WAL)
+	}
+	if len(db.state.Objects) != 4 {
+		t.Errorf("SetBatch should track added values, but %v", db.state.Objects)
+	}
+	keys, _ := db.Keys()
+	if strings.Join(keys, ",") != "a,b,c" {
+		t.Errorf("SetBatch should keep existing keys, but %v", keys)
+	}
+	var records []Record
+	db.Each(func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if len(records) != 3 || records[0].Value != "4" || records[0].Timestamp != "1500000001" || records[0].Writer != "QmOther" {
+		t.Errorf("SetBatch should keep the last value and the metadata of records, but %+v", records)
+	}
+	if records[1].Writer != "QmPeer" {
+		t.Errorf("SetBatch should record the writer of records without one, but %+v", records[1])
+	}
+
+	_, err = db.SetBatch([]Record{{Key: "d", Value: "5"}, {Key: "", Value: "6"}})
+	if !errors.Is(err, ErrInvalidKey) || calls["add"] != 3 {
+		t.Errorf("SetBatch should reject invalid keys before uploading, but %v (%v)", err, calls)
+	}
+}
+
+func TestKaleidoScopeResolveReplaysWAL(t *testing.T) {
+	var patched []string
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := r.URL.Query()["arg"]
+		switch r.URL.Path {
+		case "/api/v0/name/resolve":
+			fmt.Fprintln(w, `{"Path":"/ipfs/QmPublished"}`)
+		case "/api/v0/object/patch/add-link":
+			patched = append(patched, "add "+args[0]+" "+args[1])
+			fmt.Fprintln(w, fmt.Sprintf(`{"Hash":"%s+%s"}`, args[0], args[1]))
+		case "/api/v0/object/patch/rm-link":
+			patched = append(patched, "rm "+args[0]+" "+args[1])
+			fmt.Fprintln(w, fmt.Sprintf(`{"Hash":"%s-%s"}`, args[0], args[1]))
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "")
+	db.keystore = testKeystore()
+	db.state.WAL = []Operation{
+		{Type: "set", Database: "dbname", Key: "a", Hash: "QmA"},
+		{Type: "del", Database: "dbname", Key: "b"},
+	}
+	err := db.resolve()
+
+	if err != nil {
+		t.Errorf("resolve should not return error, but %s", err)
+	}
+	if strings.Join(patched, ",") != "add /ipfs/QmPublished a,rm /ipfs/QmPublished+a b" {
+		t.Errorf("resolve should replay the WAL onto the published head, but %v", patched)
+	}
+	if db.Head() != "/ipfs/QmPublished+a-b" || db.state.Head != db.Head() {
+		t.Errorf("resolve should use and persist the replayed head, but %s (state %s)", db.Head(), db.state.Head)
+	}
+	r := db.Recovery()
+	if !r.Ahead() || r.Published != "/ipfs/QmPublished" || r.Replayed != 2 {
+		t.Errorf("resolve should report the recovery ahead of the published head, but %+v", r)
+	}
+}
+
+func TestKaleidoScopeSaveTruncatesWAL(t *testing.T) {
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/id":
+			fmt.Fprintln(w, `{"ID":"QmTestPeer"}`)
+		case "/api/v0/add":
+			fmt.Fprintln(w, `{"Name":"","Hash":"QmValue","Size":"67"}`)
+		case "/api/v0/object/patch/add-link":
+			fmt.Fprintln(w, `{"Hash":"QmRootAfter"}`)
+		case "/api/v0/name/publish":
+			fmt.Fprintln(w, `{"Name":"QmSomeName","Value":"/ipfs/QmRootAfter"}`)
+		case "/api/v0/pin/add":
+			fmt.Fprintln(w, `{"Pins":["QmRootAfter"]}`)
+		case "/api/v0/object/links":
+			fmt.Fprintln(w, `{"Hash":"QmRootAfter","Links":[{"Name":"a","Hash":"QmValue"}]}`)
+		case "/api/v0/pin/rm":
+			fmt.Fprintln(w, `{"Pins":["QmValue"]}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+	_, err := db.Set("a", "1")
+	if err != nil {
+		t.Errorf("Set should not return error, but %s", err)
+	}
+	if len(db.state.WAL) != 1 || db.state.WAL[0].Key != "a" || db.state.Head != "QmRootAfter" {
+		t.Errorf("Set should log the operation and the new head, but %+v", db.state)
+	}
+
+	err = db.Save()
+	if err != nil {
+		t.Errorf("Save should not return error, but %s", err)
+	}
+	if len(db.state.WAL) != 0 || db.state.Published != "QmRootAfter" || db.Recovery().Ahead() {
+		t.Errorf("Save should truncate the WAL and record the published head, but %+v", db.state)
+	}
+}
+
+func testKaleidoScope(url string) *Kaleidoscope {
+	kes := newKaleidoscope(testClient(url))
+	kes.persistence = false
 	return kes
 }
+
+func testDB(url, head string) *DB {
+	db := newDB(testClient(url), "dbname")
+	db.keystore.persistence = false
+	db.state.persistence = false
+	db.use(head)
+	return db
+}
@@ -0,0 +1,146 @@
+package kaleidoscopepb
+
+import (
+	"context"
+
+	"github.com/monochromegane/kaleidoscope"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Remote struct {
+	client KaleidoscopeClient
+	conn   *grpc.ClientConn
+	dbname string
+}
+
+func Dial(target string, opts ...grpc.DialOption) (*Remote, error) {
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{
+		client: NewKaleidoscopeClient(conn),
+		conn:   conn,
+	}, nil
+}
+
+func (r *Remote) Close() error {
+	return r.conn.Close()
+}
+
+func (r *Remote) Create(dbname string, size int) (string, error) {
+	resp, err := r.client.Create(context.Background(), &CreateRequest{Database: dbname, Size: int32(size)})
+	if err != nil {
+		return "", remoteError(err)
+	}
+	r.dbname = dbname
+	return resp.Hash, nil
+}
+
+func (r *Remote) Use(dbname string) error {
+	_, err := r.client.Use(context.Background(), &UseRequest{Database: dbname})
+	if err != nil {
+		return remoteError(err)
+	}
+	r.dbname = dbname
+	return nil
+}
+
+func (r *Remote) Get(key string) ([]byte, []byte, error) {
+	resp, err := r.client.Get(context.Background(), &GetRequest{Database: r.dbname, Key: key})
+	if err != nil {
+		return []byte{}, []byte{}, remoteError(err)
+	}
+	return resp.Meta, resp.Value, nil
+}
+
+func (r *Remote) Set(key, value string) (string, error) {
+	resp, err := r.client.Set(context.Background(), &SetRequest{Database: r.dbname, Key: key, Value: value})
+	if err != nil {
+		return "", remoteError(err)
+	}
+	return resp.Hash, nil
+}
+
+func (r *Remote) Del(key string) (string, error) {
+	resp, err := r.client.Del(context.Background(), &DelRequest{Database: r.dbname, Key: key})
+	if err != nil {
+		return "", remoteError(err)
+	}
+	return resp.Hash, nil
+}
+
+func (r *Remote) Save() error {
+	_, err := r.client.Save(context.Background(), &SaveRequest{Database: r.dbname})
+	if err != nil {
+		return remoteError(err)
+	}
+	return nil
+}
+
+func (r *Remote) Watch(ctx context.Context) (<-chan *Event, error) {
+	stream, err := r.client.Watch(ctx, &WatchRequest{Database: r.dbname})
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	events := make(chan *Event)
+	go func() {
+		defer close(events)
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// StatusError is an error returned by the server. It keeps the message and
+// the status of the server, and unwraps to the error of the kaleidoscope
+// package the status was mapped from, so callers can use errors.Is with
+// kaleidoscope.ErrNotFound and the like.
+type StatusError struct {
+	Status *status.Status
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.Status.Message()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StatusError) GRPCStatus() *status.Status {
+	return e.Status
+}
+
+func remoteError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var sentinel error
+	switch st.Code() {
+	case codes.NotFound:
+		sentinel = kaleidoscope.ErrNotFound
+	case codes.Aborted, codes.FailedPrecondition:
+		sentinel = kaleidoscope.ErrConflict
+	case codes.InvalidArgument:
+		sentinel = kaleidoscope.ErrInvalidKey
+	case codes.Unavailable:
+		sentinel = kaleidoscope.ErrDaemonUnavailable
+	default:
+		return err
+	}
+	return &StatusError{Status: st, Err: sentinel}
+}
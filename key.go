@@ -0,0 +1,96 @@
+package kaleidoscope
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MaxKeyLength = 1024
+
+var ErrInvalidKey = errors.New("invalid key")
+
+type KeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s %q: %s", ErrInvalidKey, e.Key, e.Reason)
+}
+
+func (e *KeyError) Is(target error) bool {
+	return target == ErrInvalidKey
+}
+
+func checkKey(key string) error {
+	switch {
+	case key == "":
+		return &KeyError{Key: key, Reason: "must not be empty"}
+	case len(key) > MaxKeyLength:
+		return &KeyError{Key: key, Reason: fmt.Sprintf("must not be longer than %d bytes", MaxKeyLength)}
+	case reserved(key):
+		return &KeyError{Key: key, Reason: "is reserved"}
+	}
+	return nil
+}
+
+// escapeKey encodes a key into a link name that is safe to use as a single
+// path segment. Printable characters are kept as they are, while '/', '%',
+// non-printable runes and invalid UTF-8 bytes are percent-encoded. Keys that
+// consist only of dots are encoded entirely so they never read as "." or "..".
+func escapeKey(key string) string {
+	if strings.Trim(key, ".") == "" {
+		return strings.Repeat("%2E", len(key))
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); {
+		r, size := utf8.DecodeRuneInString(key[i:])
+		if r == '/' || r == '%' || (r == utf8.RuneError && size == 1) || !unicode.IsPrint(r) {
+			for _, c := range []byte(key[i : i+size]) {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		} else {
+			b.WriteString(key[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// unescapeKey reverses escapeKey. Names that are not valid encodings, such as
+// links written before keys were escaped, are returned unchanged.
+func unescapeKey(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) || !isHex(name[i+1]) || !isHex(name[i+2]) {
+			return name
+		}
+		b.WriteByte(unhex(name[i+1])<<4 | unhex(name[i+2]))
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('A' <= c && c <= 'F') || ('a' <= c && c <= 'f')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,138 @@
+package kaleidoscope
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+// randomKey generates keys biased towards characters that are special in
+// paths, escapes and unicode.
+type randomKey string
+
+func (randomKey) Generate(r *rand.Rand, size int) reflect.Value {
+	alphabet := []string{"a", "Z", "0", "/", "%", ".", " ", "_", "-", "\x00", "\n", "\x7f", "\xff", "é", "​", "‮", "日本", "🔑", "%2F"}
+	var b strings.Builder
+	n := r.Intn(size + 1)
+	for i := 0; i < n; i++ {
+		b.WriteString(alphabet[r.Intn(len(alphabet))])
+	}
+	return reflect.ValueOf(randomKey(b.String()))
+}
+
+func TestEscapeKeyRoundTrip(t *testing.T) {
+	roundTrip := func(k randomKey) bool {
+		return unescapeKey(escapeKey(string(k))) == string(k)
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+	arbitrary := func(s string) bool {
+		return unescapeKey(escapeKey(s)) == s
+	}
+	if err := quick.Check(arbitrary, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEscapeKeyIsSafeSegment(t *testing.T) {
+	safe := func(k randomKey) bool {
+		name := escapeKey(string(k))
+		if strings.Contains(name, "/") || name == "." || name == ".." || !utf8.ValidString(name) {
+			return false
+		}
+		for _, r := range name {
+			if r < 0x20 || r == 0x7f || r == '​' || r == '‮' {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(safe, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEscapeKeyIsInjective(t *testing.T) {
+	distinct := func(a, b randomKey) bool {
+		return a == b || escapeKey(string(a)) != escapeKey(string(b))
+	}
+	if err := quick.Check(distinct, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEscapeKeyKeepsPlainKeys(t *testing.T) {
+	for _, key := range []string{"alice", "__database_name", "user:1", "a.b", "日本語", "with space"} {
+		if escapeKey(key) != key {
+			t.Errorf("escapeKey should keep %q as it is, but %q", key, escapeKey(key))
+		}
+	}
+	if unescapeKey("100%") != "100%" {
+		t.Errorf("unescapeKey should keep names that are not escaped, but %q", unescapeKey("100%"))
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	for _, key := range []string{"", indexRoot, collectionRoot, strings.Repeat("k", MaxKeyLength+1)} {
+		err := checkKey(key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("checkKey(%q) should return ErrInvalidKey, but %v", key, err)
+		}
+		var kerr *KeyError
+		if !errors.As(err, &kerr) || kerr.Key != key {
+			t.Errorf("checkKey(%q) should return a KeyError, but %v", key, err)
+		}
+	}
+}
+
+func TestDBArbitraryKeys(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, EmptyDirMultiHash)
+	db.keystore = testKeystore()
+	written := map[string]string{}
+	check := func(k randomKey) bool {
+		key := string(k)
+		if key == "" {
+			_, err := db.Set(key, "value")
+			return errors.Is(err, ErrInvalidKey)
+		}
+		value := fmt.Sprintf("value %d", len(written))
+		_, err := db.Set(key, value)
+		if err != nil {
+			t.Logf("Set(%q) returned %s", key, err)
+			return false
+		}
+		written[key] = value
+		_, stored, err := db.Get(key)
+		return err == nil && string(stored) == value
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 50}); err != nil {
+		t.Error(err)
+	}
+
+	keys, _ := db.Keys()
+	if len(keys) != len(written) {
+		t.Errorf("Keys should list every written key once, but %d keys for %d writes", len(keys), len(written))
+	}
+	for _, key := range keys {
+		if _, ok := written[key]; !ok {
+			t.Errorf("Keys should return unescaped keys, but %q", key)
+		}
+	}
+	for key := range written {
+		if _, err := db.Del(key); err != nil {
+			t.Errorf("Del(%q) should not return error, but %s", key, err)
+		}
+	}
+	if keys, _ := db.Keys(); len(keys) != 0 {
+		t.Errorf("Del should remove every key, but %v", keys)
+	}
+}
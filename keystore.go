@@ -1,6 +1,9 @@
 package kaleidoscope
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -57,7 +60,24 @@ func (k Keystore) Decrypt(enc []byte) ([]byte, error) {
 	if err != nil {
 		return []byte{}, err
 	}
-	return priv.Decrypt(enc)
+	plain, err := priv.Decrypt(enc)
+	if err != nil {
+		return []byte{}, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return plain, nil
+}
+
+func (k Keystore) Digest(parts ...string) (string, error) {
+	secret, err := k.priv.Bytes()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	for _, part := range parts {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
 }
 
 func (k Keystore) PeerID() (string, error) {
@@ -78,17 +98,11 @@ func (k Keystore) key() (*ci.RsaPublicKey, *ci.RsaPrivateKey, error) {
 }
 
 func (k *Keystore) loadFromFile(keypair string) error {
-	baseDir := os.Getenv(EnvDir)
-	if baseDir == "" {
-		baseDir = DefaultPathRoot
-	}
-
-	baseDir, err := homedir.Expand(baseDir)
+	keystore, err := keystoreDir()
 	if err != nil {
 		return err
 	}
 
-	keystore := path.Join(baseDir, DefaultKeystoreRoot)
 	data, err := ioutil.ReadFile(filepath.Join(keystore, keypair))
 	if err != nil {
 		return err
@@ -104,6 +118,20 @@ func (k *Keystore) loadFromFile(keypair string) error {
 	return nil
 }
 
+func keystoreDir() (string, error) {
+	baseDir := os.Getenv(EnvDir)
+	if baseDir == "" {
+		baseDir = DefaultPathRoot
+	}
+
+	baseDir, err := homedir.Expand(baseDir)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(baseDir, DefaultKeystoreRoot), nil
+}
+
 // func NewKeyStore(keypair string) (Keystore, error) {
 // 	baseDir := os.Getenv(EnvDir)
 // 	if baseDir == "" {
@@ -0,0 +1,67 @@
+package kaleidoscope
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type metadata struct {
+	timestamp []byte
+	expires   int64
+	codec     string
+	layout    string
+	writer    string
+}
+
+func wrap(value string, m metadata) string {
+	if m.expires == 0 && m.codec == "" && m.layout == "" && m.writer == "" && len(m.timestamp) == 0 {
+		return wrapWithMetadata(value)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	if len(m.timestamp) > 0 {
+		timestamp = string(m.timestamp)
+	}
+	fields := []string{
+		timestamp,
+		strconv.FormatInt(m.expires, 10),
+	}
+	if m.codec != "" || m.layout != "" || m.writer != "" {
+		fields = append(fields, m.codec)
+	}
+	if m.layout != "" || m.writer != "" {
+		fields = append(fields, m.layout)
+	}
+	if m.writer != "" {
+		fields = append(fields, m.writer)
+	}
+	return strings.Join(fields, ";") + "," + value
+}
+
+func unwrap(plain []byte) (metadata, []byte, error) {
+	i := bytes.IndexByte(plain, ',')
+	if i < 0 {
+		return metadata{}, []byte{}, fmt.Errorf("Malformed value metadata")
+	}
+	fields := bytes.Split(plain[:i], []byte(";"))
+	m := metadata{timestamp: fields[0]}
+	if len(fields) > 1 {
+		expires, err := strconv.ParseInt(string(fields[1]), 10, 64)
+		if err != nil {
+			return metadata{}, []byte{}, err
+		}
+		m.expires = expires
+	}
+	if len(fields) > 2 {
+		m.codec = string(fields[2])
+	}
+	if len(fields) > 3 {
+		m.layout = string(fields[3])
+	}
+	if len(fields) > 4 {
+		m.writer = string(fields[4])
+	}
+	return m, plain[i+1:], nil
+}
@@ -0,0 +1,30 @@
+package kaleidoscope
+
+import (
+	"testing"
+)
+
+func TestUnwrap(t *testing.T) {
+	m, value, err := unwrap([]byte("1500000000;1500003600;json,some,value"))
+	if err != nil {
+		t.Errorf("unwrap should not return error, but %s", err)
+	}
+	if string(m.timestamp) != "1500000000" || m.expires != 1500003600 || m.codec != "json" {
+		t.Errorf("unwrap should parse timestamp, expiry and codec, but %+v", m)
+	}
+	if string(value) != "some,value" {
+		t.Errorf("unwrap should return value (some,value), but %s", value)
+	}
+
+	m, value, err = unwrap([]byte("1500000000,some value"))
+	if err != nil || string(m.timestamp) != "1500000000" || m.expires != 0 || m.codec != "" || string(value) != "some value" {
+		t.Errorf("unwrap should accept values without metadata fields, but %+v %s %v", m, value, err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	m, value, _ := unwrap([]byte(wrap("v", metadata{codec: "gob"})))
+	if m.expires != 0 || m.codec != "gob" || string(value) != "v" {
+		t.Errorf("wrap should round-trip metadata, but %+v %s", m, value)
+	}
+}
@@ -0,0 +1,158 @@
+package kaleidoscope
+
+import (
+	"strings"
+)
+
+const DefaultRetention = 3
+
+type PinStatus struct {
+	Root   string
+	Pinned bool
+	Values []ValueStatus
+}
+
+type ValueStatus struct {
+	Collection string
+	Key        string
+	Hash       string
+	Available  bool
+}
+
+func (s PinStatus) Healthy() bool {
+	if !s.Pinned {
+		return false
+	}
+	for _, v := range s.Values {
+		if !v.Available {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *DB) SetRetention(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	d.retention = n
+}
+
+func (d *DB) PinStatus() (PinStatus, error) {
+	d.mu.Lock()
+	root := d.latest()
+	d.mu.Unlock()
+
+	status := PinStatus{Root: root}
+	_, err := d.client.PinLs(root, RequestOptions{"type": "recursive"})
+	if err == nil {
+		status.Pinned = true
+	} else if !isNotPinned(err) {
+		return status, err
+	}
+
+	entries, err := d.entries(root)
+	if err != nil {
+		return status, err
+	}
+	for _, entry := range entries {
+		_, err := d.client.BlockStat(entry.Hash+"/value", RequestOptions{"offline": "true"})
+		status.Values = append(status.Values, ValueStatus{
+			Collection: entry.Collection,
+			Key:        entry.Key,
+			Hash:       entry.Hash,
+			Available:  err == nil,
+		})
+	}
+	return status, nil
+}
+
+func (d *DB) RepairPins() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Pinning recursively fetches every missing block from the network.
+	_, err := d.client.PinAdd(d.latest(), RequestOptions{"recursive": "true"})
+	if err != nil {
+		return err
+	}
+	return d.record(d.latest())
+}
+
+func (d *DB) pin(root string) error {
+	if n := len(d.state.Pins); n > 0 && d.state.Pins[n-1] == root {
+		return nil
+	}
+	_, err := d.client.PinAdd(root, RequestOptions{"recursive": "true"})
+	if err != nil {
+		return err
+	}
+	return d.record(root)
+}
+
+func (d *DB) record(root string) error {
+	pins := []string{}
+	for _, p := range d.state.Pins {
+		if p != root {
+			pins = append(pins, p)
+		}
+	}
+	pins = append(pins, root)
+
+	for len(pins) > d.retention {
+		_, err := d.client.PinRm(pins[0], RequestOptions{"recursive": "true"})
+		if err != nil && !isNotPinned(err) {
+			return err
+		}
+		pins = pins[1:]
+	}
+	d.state.Pins = pins
+	return d.state.Write()
+}
+
+// release unpins the values held by the pinned root, which keeps them alive
+// on its own, so Objects only tracks values no pinned root refers to.
+func (d *DB) release(root string) error {
+	if len(d.state.Objects) == 0 {
+		return nil
+	}
+	links, err := d.links(root)
+	if err != nil {
+		return err
+	}
+	held := map[string]bool{}
+	for _, link := range links {
+		held[link.Hash] = true
+		if !reserved(link.Name) {
+			continue
+		}
+		hashes, err := d.indexObjects(link.Hash)
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			held[hash] = true
+		}
+	}
+	objects := []string{}
+	for _, hash := range d.state.Objects {
+		if !held[hash] {
+			objects = append(objects, hash)
+			continue
+		}
+		_, err := d.client.PinRm(hash, RequestOptions{"recursive": "true"})
+		if err != nil && !isNotPinned(err) {
+			return err
+		}
+	}
+	if len(objects) == len(d.state.Objects) {
+		return nil
+	}
+	d.state.Objects = objects
+	return d.state.Write()
+}
+
+func isNotPinned(err error) bool {
+	return strings.Contains(err.Error(), "not pinned")
+}
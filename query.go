@@ -0,0 +1,385 @@
+package kaleidoscope
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var predicateOps = []string{"!=", "<=", ">=", "=", "<", ">", "~"}
+
+// Predicate compares a dot-separated field of a JSON value. A predicate
+// without Op only requires the field to exist.
+type Predicate struct {
+	Field string
+	Op    string
+	Value interface{}
+	re    *regexp.Regexp
+}
+
+// ParsePredicate parses expressions such as `age>=30`, `team=red` or
+// `name~^a`. The right-hand side is decoded as JSON when possible and
+// treated as a string otherwise.
+func ParsePredicate(expr string) (Predicate, error) {
+	for i := 0; i < len(expr); i++ {
+		for _, op := range predicateOps {
+			if !strings.HasPrefix(expr[i:], op) {
+				continue
+			}
+			field := strings.TrimSpace(expr[:i])
+			if field == "" {
+				return Predicate{}, fmt.Errorf("Predicate %q has no field", expr)
+			}
+			rhs := strings.TrimSpace(expr[i+len(op):])
+			if op != "~" {
+				return Predicate{Field: field, Op: op, Value: Literal(rhs)}, nil
+			}
+			re, err := regexp.Compile(rhs)
+			if err != nil {
+				return Predicate{}, err
+			}
+			return Predicate{Field: field, Op: op, Value: rhs, re: re}, nil
+		}
+	}
+	field := strings.TrimSpace(expr)
+	if field == "" {
+		return Predicate{}, fmt.Errorf("Empty predicate")
+	}
+	return Predicate{Field: field}, nil
+}
+
+func (p Predicate) Match(doc interface{}) bool {
+	v, ok := lookupField(doc, p.Field)
+	if !ok {
+		return false
+	}
+	if p.Op == "" {
+		return true
+	}
+	if p.Op == "~" {
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		re := p.re
+		if re == nil {
+			var err error
+			re, err = regexp.Compile(fmt.Sprint(p.Value))
+			if err != nil {
+				return false
+			}
+		}
+		return re.MatchString(s)
+	}
+	c, ok := compare(v, normalize(p.Value))
+	switch p.Op {
+	case "!=":
+		return !ok || c != 0
+	case "=":
+		return ok && c == 0
+	case "<":
+		return ok && c < 0
+	case "<=":
+		return ok && c <= 0
+	case ">":
+		return ok && c > 0
+	case ">=":
+		return ok && c >= 0
+	}
+	return false
+}
+
+// compile returns p with its regexp compiled, so that predicates built
+// without ParsePredicate do not compile it for every record.
+func (p Predicate) compile() (Predicate, error) {
+	if p.Op != "~" || p.re != nil {
+		return p, nil
+	}
+	re, err := regexp.Compile(fmt.Sprint(p.Value))
+	if err != nil {
+		return Predicate{}, err
+	}
+	p.re = re
+	return p, nil
+}
+
+func (p Predicate) String() string {
+	if p.Op == "" {
+		return p.Field
+	}
+	if s, ok := p.Value.(string); ok {
+		return p.Field + p.Op + s
+	}
+	data, _ := json.Marshal(p.Value)
+	return p.Field + p.Op + string(data)
+}
+
+// Filter selects records by key, JSON value and metadata. Zero fields
+// are ignored. After is inclusive and Before is exclusive.
+type Filter struct {
+	Glob   string
+	Regexp string
+	Where  []Predicate
+	After  time.Time
+	Before time.Time
+	Writer string
+	Limit  int
+	Offset int
+}
+
+// Results iterates lazily over the records matching a Filter. Values are
+// read from the head the query started on.
+type Results struct {
+	db      *DB
+	filter  Filter
+	re      *regexp.Regexp
+	links   []Link
+	pos     int
+	skipped int
+	count   int
+	record  Record
+	err     error
+}
+
+func (d *DB) Find(f Filter) (*Results, error) {
+	var re *regexp.Regexp
+	if f.Regexp != "" {
+		var err error
+		re, err = regexp.Compile(f.Regexp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.Limit < 0 || f.Offset < 0 {
+		return nil, fmt.Errorf("Limit and offset must not be negative")
+	}
+	where := make([]Predicate, len(f.Where))
+	for i, p := range f.Where {
+		var err error
+		where[i], err = p.compile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	f.Where = where
+
+	d.mu.Lock()
+	root := d.latest()
+	links, err := d.links(root)
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &Results{db: d, filter: f, re: re, links: links}, nil
+}
+
+func (r *Results) Next() bool {
+	if r.err != nil || r.links == nil {
+		return false
+	}
+	for r.pos < len(r.links) {
+		if r.filter.Limit > 0 && r.count >= r.filter.Limit {
+			break
+		}
+		link := r.links[r.pos]
+		r.pos++
+		if reserved(link.Name) || !r.matchKey(unescapeKey(link.Name)) {
+			continue
+		}
+		record, ok, err := r.db.match(link, r.filter)
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if !ok {
+			continue
+		}
+		if r.skipped < r.filter.Offset {
+			r.skipped++
+			continue
+		}
+		r.count++
+		r.record = record
+		return true
+	}
+	r.Close()
+	return false
+}
+
+func (r *Results) Record() Record {
+	return r.record
+}
+
+func (r *Results) Err() error {
+	return r.err
+}
+
+func (r *Results) Close() {
+	r.links = nil
+}
+
+func (r *Results) matchKey(key string) bool {
+	if r.filter.Glob != "" && !MatchGlob(r.filter.Glob, key) {
+		return false
+	}
+	return r.re == nil || r.re.MatchString(key)
+}
+
+// match reads the value without holding d.mu, links are immutable once
+// Find listed them.
+func (d *DB) match(link Link, f Filter) (Record, bool, error) {
+	plain, err := d.plain(link.Hash)
+	if err != nil {
+		return Record{}, false, err
+	}
+	m, value, err := unwrap(plain)
+	if err != nil {
+		return Record{}, false, err
+	}
+	d.remember(link.Hash, m.expires)
+	if expired(m.expires) {
+		return Record{}, false, nil
+	}
+	if f.Writer != "" && m.writer != f.Writer {
+		return Record{}, false, nil
+	}
+	if !f.After.IsZero() || !f.Before.IsZero() {
+		ts, err := strconv.ParseInt(string(m.timestamp), 10, 64)
+		if err != nil {
+			return Record{}, false, nil
+		}
+		written := time.Unix(ts, 0)
+		if !f.After.IsZero() && written.Before(f.After.Truncate(time.Second)) {
+			return Record{}, false, nil
+		}
+		if !f.Before.IsZero() && !written.Before(f.Before) {
+			return Record{}, false, nil
+		}
+	}
+	if m.layout == chunkedLayout {
+		value, err = d.assemble(link.Hash, value)
+		if err != nil {
+			return Record{}, false, err
+		}
+	}
+	if len(f.Where) > 0 {
+		var doc interface{}
+		if json.Unmarshal(value, &doc) != nil {
+			return Record{}, false, nil
+		}
+		for _, p := range f.Where {
+			if !p.Match(doc) {
+				return Record{}, false, nil
+			}
+		}
+	}
+	return Record{
+		Key:       unescapeKey(link.Name),
+		Value:     string(value),
+		Timestamp: string(m.timestamp),
+		Expires:   m.expires,
+		Codec:     m.codec,
+		Writer:    m.writer,
+	}, true, nil
+}
+
+// Literal decodes s as a JSON value, or returns it as a string when it is
+// not valid JSON.
+func Literal(s string) interface{} {
+	var v interface{}
+	if json.Unmarshal([]byte(s), &v) == nil {
+		return v
+	}
+	return s
+}
+
+// MatchGlob reports whether key matches a Redis style glob pattern: '*'
+// matches any sequence and '?' any character, '/' included since keys may
+// contain it, '[...]' matches a class of characters and '\' escapes.
+func MatchGlob(pattern, key string) bool {
+	p, k := []rune(pattern), []rune(key)
+	pi, ki := 0, 0
+	star, mark := -1, 0
+	for ki < len(k) {
+		if pi < len(p) {
+			switch p[pi] {
+			case '*':
+				star, mark = pi, ki
+				pi++
+				continue
+			case '?':
+				pi++
+				ki++
+				continue
+			case '[':
+				if ok, n := matchClass(p[pi+1:], k[ki]); ok {
+					pi += 1 + n
+					ki++
+					continue
+				}
+			case '\\':
+				lit, n := p[pi], 1
+				if pi+1 < len(p) {
+					lit, n = p[pi+1], 2
+				}
+				if lit == k[ki] {
+					pi += n
+					ki++
+					continue
+				}
+			default:
+				if p[pi] == k[ki] {
+					pi++
+					ki++
+					continue
+				}
+			}
+		}
+		// Let the last '*' swallow one more character and retry.
+		if star < 0 {
+			return false
+		}
+		pi = star + 1
+		mark++
+		ki = mark
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// matchClass matches c against the class at the start of p, just after its
+// '[', and returns the length of the class including the closing ']'.
+func matchClass(p []rune, c rune) (bool, int) {
+	i := 0
+	negate := i < len(p) && p[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for i < len(p) && p[i] != ']' {
+		switch {
+		case p[i] == '\\' && i+1 < len(p):
+			matched = matched || p[i+1] == c
+			i += 2
+		case i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']':
+			lo, hi := p[i], p[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || lo <= c && c <= hi
+			i += 3
+		default:
+			matched = matched || p[i] == c
+			i++
+		}
+	}
+	if i < len(p) {
+		i++
+	}
+	return matched != negate, i
+}
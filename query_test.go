@@ -0,0 +1,186 @@
+package kaleidoscope
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func findKeys(t *testing.T, db *DB, f Filter) []string {
+	results, err := db.Find(f)
+	if err != nil {
+		t.Fatalf("Find should not return error, but %s", err)
+	}
+	defer results.Close()
+	keys := []string{}
+	for results.Next() {
+		keys = append(keys, results.Record().Key)
+	}
+	if err := results.Err(); err != nil {
+		t.Errorf("Results should not return error, but %s", err)
+	}
+	return keys
+}
+
+func TestParsePredicate(t *testing.T) {
+	cases := []struct {
+		expr  string
+		field string
+		op    string
+		value interface{}
+	}{
+		{"age>=30", "age", ">=", float64(30)},
+		{"team = red", "team", "=", "red"},
+		{"a.b!=true", "a.b", "!=", true},
+		{"name~^a", "name", "~", "^a"},
+		{"team", "team", "", nil},
+	}
+	for _, c := range cases {
+		p, err := ParsePredicate(c.expr)
+		if err != nil {
+			t.Errorf("ParsePredicate(%q) should not return error, but %s", c.expr, err)
+		}
+		if p.Field != c.field || p.Op != c.op || p.Value != c.value {
+			t.Errorf("ParsePredicate(%q) should return %s %s %v, but %+v", c.expr, c.field, c.op, c.value, p)
+		}
+	}
+	for _, expr := range []string{"", "=red", "name~("} {
+		if _, err := ParsePredicate(expr); err == nil {
+			t.Errorf("ParsePredicate(%q) should return error", expr)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"*", "users/alice", true},
+		{"users/*", "users/alice/notes", true},
+		{"*/alice", "users/alice", true},
+		{"user?/alice", "users/alice", true},
+		{"?", "/", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"", "", true},
+		{"", "a", false},
+		{"**", "", true},
+	}
+	for _, c := range cases {
+		if MatchGlob(c.pattern, c.key) != c.match {
+			t.Errorf("MatchGlob(%q, %q) should be %v", c.pattern, c.key, c.match)
+		}
+	}
+}
+
+func TestDBFind(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testIndexedDB(ipfs.URL)
+	where := func(exprs ...string) []Predicate {
+		preds := []Predicate{}
+		for _, expr := range exprs {
+			p, _ := ParsePredicate(expr)
+			preds = append(preds, p)
+		}
+		return preds
+	}
+
+	cases := []struct {
+		filter Filter
+		keys   string
+	}{
+		{Filter{}, "alice,bob,carol,note"},
+		{Filter{Glob: "?o*"}, "bob,note"},
+		{Filter{Regexp: "^(alice|carol)$"}, "alice,carol"},
+		{Filter{Where: where("team=red")}, "alice,carol"},
+		{Filter{Where: where("team=red", "age<40")}, "alice"},
+		{Filter{Where: where("team!=red")}, "bob"},
+		{Filter{Where: where("team~^b")}, "bob"},
+		{Filter{Where: []Predicate{{Field: "team", Op: "~", Value: "^b"}}}, "bob"},
+		{Filter{Where: where("age")}, "alice,bob,carol"},
+		{Filter{Where: where("age>=25"), Offset: 1, Limit: 1}, "bob"},
+		{Filter{Before: time.Now().Add(-time.Hour)}, ""},
+		{Filter{After: time.Now().Add(-time.Hour)}, "alice,bob,carol,note"},
+	}
+	for _, c := range cases {
+		keys := findKeys(t, db, c.filter)
+		if strings.Join(keys, ",") != c.keys {
+			t.Errorf("Find(%+v) should return %s, but %v", c.filter, c.keys, keys)
+		}
+	}
+
+	db.Set("team/red", "alice,carol")
+	if keys := findKeys(t, db, Filter{Glob: "team*"}); strings.Join(keys, ",") != "team/red" {
+		t.Errorf("Find should match keys containing '/' with '*', but %v", keys)
+	}
+
+	if _, err := db.Find(Filter{Regexp: "("}); err == nil {
+		t.Errorf("Find should return error for an invalid regexp")
+	}
+	if _, err := db.Find(Filter{Where: []Predicate{{Field: "team", Op: "~", Value: "("}}}); err == nil {
+		t.Errorf("Find should return error for an invalid predicate regexp")
+	}
+}
+
+func TestDBFindReadsWithoutLock(t *testing.T) {
+	dag, unused := newTestDAG()
+	unused.Close()
+
+	var db atomic.Value
+	var locked int32
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, ok := db.Load().(*DB); ok && r.URL.Path == "/api/v0/cat" {
+			acquired := make(chan struct{})
+			go func() {
+				d.mu.Lock()
+				d.mu.Unlock()
+				close(acquired)
+			}()
+			select {
+			case <-acquired:
+			case <-time.After(time.Second):
+				atomic.StoreInt32(&locked, 1)
+			}
+		}
+		dag.ServeHTTP(w, r)
+	}))
+	defer ipfs.Close()
+
+	d := testIndexedDB(ipfs.URL)
+	db.Store(d)
+	keys := findKeys(t, d, Filter{Where: []Predicate{{Field: "age"}}})
+	if len(keys) == 0 || atomic.LoadInt32(&locked) != 0 {
+		t.Errorf("Find should read values without holding the database lock, but %v", keys)
+	}
+}
+
+func TestDBFindWriter(t *testing.T) {
+	_, ipfs := newTestDAG()
+	defer ipfs.Close()
+
+	db := testIndexedDB(ipfs.URL)
+	db.peer = "QmPeer"
+	db.Set("dave", `{"team":"red"}`)
+
+	keys := findKeys(t, db, Filter{Writer: "QmPeer"})
+	if strings.Join(keys, ",") != "dave" {
+		t.Errorf("Find should return keys written by the peer, but %v", keys)
+	}
+	results, _ := db.Find(Filter{Writer: "QmPeer"})
+	results.Next()
+	if r := results.Record(); r.Writer != "QmPeer" || r.Value != `{"team":"red"}` {
+		t.Errorf("Record should carry the writer and value, but %+v", r)
+	}
+}
@@ -1,6 +1,7 @@
 package kaleidoscope
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -59,18 +60,26 @@ func (r Request) Send(c http.Client) (*Response, error) {
 		req.Header.Set(k, v)
 	}
 
+	cancel := context.CancelFunc(func() {})
+	if d := requestTimeout(r.Opts); d > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(context.Background(), d)
+		req = req.WithContext(ctx)
+	}
+
 	resp, err := c.Do(req)
 	if err != nil {
-		return nil, err
+		cancel()
+		return nil, fmt.Errorf("%w: %w", ErrDaemonUnavailable, err)
 	}
 
 	contentType := resp.Header.Get("Content-Type")
 	parts := strings.Split(contentType, ";")
 	contentType = parts[0]
 
-	nresp := new(Response)
+	nresp := &Response{cancel: cancel}
 
-	nresp.Output = resp.Body
+	nresp.Output = &streamReader{body: resp.Body, resp: resp, command: r.Command}
 	if resp.StatusCode >= http.StatusBadRequest {
 		e := &Error{
 			Command: r.Command,
@@ -95,11 +104,13 @@ func (r Request) Send(c http.Client) (*Response, error) {
 				e.Message = fmt.Sprintf("unknown error: %q - %q", contentType, out)
 			}
 		}
+		e.Err = classify(resp.StatusCode, e.Message)
 		nresp.Error = e
 		nresp.Output = nil
 
 		ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
+		cancel()
 	}
 
 	return nresp, nil
@@ -118,12 +129,38 @@ func (r *Request) getURL() string {
 	return fmt.Sprintf("%s/%s?%s", r.ApiBase, r.Command, values.Encode())
 }
 
+// streamReader reports an error sent in the X-Stream-Error trailer, which the
+// daemon uses when a command fails after the response has started.
+type streamReader struct {
+	body    io.ReadCloser
+	resp    *http.Response
+	command string
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if err == io.EOF {
+		if msg := s.resp.Trailer.Get("X-Stream-Error"); msg != "" {
+			return n, &Error{Command: s.command, Message: msg, Err: classify(0, msg)}
+		}
+	}
+	return n, err
+}
+
+func (s *streamReader) Close() error {
+	return s.body.Close()
+}
+
 type Response struct {
 	Output io.ReadCloser
 	Error  *Error
+	cancel context.CancelFunc
 }
 
 func (r *Response) Close() error {
+	if r.cancel != nil {
+		defer r.cancel()
+	}
 	if r.Output != nil {
 		// always drain output (response body)
 		ioutil.ReadAll(r.Output)
@@ -136,6 +173,7 @@ type Error struct {
 	Command string
 	Message string
 	Code    int
+	Err     error `json:"-"`
 }
 
 func (e *Error) Error() string {
@@ -148,3 +186,7 @@ func (e *Error) Error() string {
 	}
 	return out + e.Message
 }
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,281 @@
+package kaleidoscope
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const DefaultStateRoot = "kaleidoscope"
+
+type State struct {
+	Database    string
+	Pins        []string
+	Objects     []string
+	Head        string
+	Published   string
+	WAL         []Operation `json:"-"`
+	Indexes     map[string]string
+	persistence bool
+}
+
+func NewState() State {
+	return State{persistence: true}
+}
+
+func (s *State) Load(dbname string) error {
+	if s.Database == dbname {
+		return nil
+	}
+	*s = State{Database: dbname, persistence: s.persistence}
+	if !s.persistence {
+		// Use only for testing.
+		return nil
+	}
+
+	file, err := stateFile(dbname)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var legacy struct{ WAL []Operation }
+	if err == nil {
+		err = json.Unmarshal(data, s)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &legacy)
+		if err != nil {
+			return err
+		}
+	}
+	s.Database = dbname
+
+	wal, err := readWAL(dbname)
+	if err != nil {
+		return err
+	}
+	s.WAL = append(legacy.WAL, wal...)
+	if len(legacy.WAL) == 0 {
+		return nil
+	}
+	// State files used to embed the WAL, move it to the log.
+	err = writeWAL(dbname, s.WAL)
+	if err != nil {
+		return err
+	}
+	return s.Write()
+}
+
+// Append logs an operation to the WAL. Only the log is written, the state
+// file itself is written at checkpoints.
+func (s *State) Append(ope Operation) error {
+	if s.persistence {
+		file, err := walFile(s.Database)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(file), 0700)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		err = appendOperation(f, ope)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.WAL = append(s.WAL, ope)
+	return nil
+}
+
+// TruncateWAL forgets the logged operations once they are published.
+func (s *State) TruncateWAL() error {
+	s.WAL = nil
+	if !s.persistence {
+		return nil
+	}
+	file, err := walFile(s.Database)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s State) Write() error {
+	if !s.persistence {
+		return nil
+	}
+	file, err := stateFile(s.Database)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func (s *State) Rename(dbname string) error {
+	old := s.Database
+	s.Database = dbname
+	err := s.Write()
+	if err == nil && s.persistence {
+		err = writeWAL(dbname, s.WAL)
+	}
+	if err != nil {
+		s.Database = old
+		return err
+	}
+	return State{Database: old, persistence: s.persistence}.Remove()
+}
+
+func (s State) Remove() error {
+	if !s.persistence {
+		return nil
+	}
+	for _, name := range []func(string) (string, error){stateFile, walFile} {
+		file, err := name(s.Database)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readWAL(dbname string) ([]Operation, error) {
+	file, err := walFile(dbname)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var wal []Operation
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var ope Operation
+		err := dec.Decode(&ope)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A torn last entry was never acknowledged to the writer.
+			return wal, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		wal = append(wal, ope)
+	}
+}
+
+func writeWAL(dbname string, wal []Operation) error {
+	file, err := walFile(dbname)
+	if err != nil {
+		return err
+	}
+	if len(wal) == 0 {
+		err = os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	for _, ope := range wal {
+		err = appendOperation(f, ope)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func appendOperation(w io.Writer, ope Operation) error {
+	data, err := json.Marshal(ope)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func stateFile(dbname string) (string, error) {
+	baseDir := os.Getenv(EnvDir)
+	if baseDir == "" {
+		baseDir = DefaultPathRoot
+	}
+
+	baseDir, err := homedir.Expand(baseDir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(path.Join(baseDir, DefaultStateRoot), dbname+".json"), nil
+}
+
+func walFile(dbname string) (string, error) {
+	file, err := stateFile(dbname)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(file, ".json") + ".wal", nil
+}
@@ -0,0 +1,81 @@
+package kaleidoscope
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStateAppendAndLoad(t *testing.T) {
+	t.Setenv(EnvDir, t.TempDir())
+
+	s := NewState()
+	s.Load("dbname")
+	s.Head = "QmHead"
+	err := s.Write()
+	if err != nil {
+		t.Fatalf("Write should not return error, but %s", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		err = s.Append(Operation{Type: "set", Database: "dbname", Key: key, Hash: "QmValue"})
+		if err != nil {
+			t.Fatalf("Append should not return error, but %s", err)
+		}
+	}
+
+	file, _ := stateFile("dbname")
+	data, _ := ioutil.ReadFile(file)
+	if strings.Contains(string(data), "WAL") {
+		t.Errorf("Write should not embed the WAL in the state file, but %s", data)
+	}
+
+	// Simulate a crash in the middle of the last entry.
+	wal, _ := walFile("dbname")
+	f, _ := os.OpenFile(wal, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"Type":"set","Key":"c"`)
+	f.Close()
+
+	loaded := NewState()
+	err = loaded.Load("dbname")
+	if err != nil {
+		t.Fatalf("Load should not return error, but %s", err)
+	}
+	if loaded.Head != "QmHead" || len(loaded.WAL) != 2 || loaded.WAL[1].Key != "b" {
+		t.Errorf("Load should restore the state and the logged operations, but %+v", loaded)
+	}
+
+	err = loaded.TruncateWAL()
+	if err != nil {
+		t.Fatalf("TruncateWAL should not return error, but %s", err)
+	}
+	if _, err := os.Stat(wal); !os.IsNotExist(err) {
+		t.Errorf("TruncateWAL should remove the log, but %v", err)
+	}
+}
+
+func TestStateLoadLegacyWAL(t *testing.T) {
+	t.Setenv(EnvDir, t.TempDir())
+
+	file, _ := stateFile("dbname")
+	os.MkdirAll(strings.TrimSuffix(file, "dbname.json"), 0700)
+	legacy := `{"Database":"dbname","Head":"QmHead","WAL":[{"Type":"set","Database":"dbname","Key":"a","Hash":"QmA"}]}`
+	ioutil.WriteFile(file, []byte(legacy), 0600)
+
+	s := NewState()
+	err := s.Load("dbname")
+	if err != nil {
+		t.Fatalf("Load should not return error, but %s", err)
+	}
+	if len(s.WAL) != 1 || s.WAL[0].Key != "a" {
+		t.Errorf("Load should keep the embedded WAL, but %+v", s.WAL)
+	}
+	data, _ := ioutil.ReadFile(file)
+	if strings.Contains(string(data), "WAL") {
+		t.Errorf("Load should move the embedded WAL to the log, but %s", data)
+	}
+	wal, err := readWAL("dbname")
+	if err != nil || len(wal) != 1 || wal[0].Key != "a" {
+		t.Errorf("Load should write the embedded WAL to the log, but %+v (%v)", wal, err)
+	}
+}
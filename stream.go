@@ -14,9 +14,13 @@ type Stream struct {
 }
 
 func (s *Stream) read() {
+	defer close(s.Data)
 	reader := bufio.NewReader(s.src)
 	for {
-		line, _ := reader.ReadBytes('\n')
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
 		var msg Message
 		json.NewDecoder(bytes.NewReader(line)).Decode(&msg)
 		data, err := base64.StdEncoding.DecodeString(msg.Data)
@@ -29,7 +33,9 @@ func (s *Stream) read() {
 }
 
 func (s *Stream) Close() {
-	defer close(s.Data)
+	if s.src == nil {
+		return
+	}
 	s.src.Close()
 	s.src = nil
 }
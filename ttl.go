@@ -0,0 +1,190 @@
+package kaleidoscope
+
+import (
+	"fmt"
+	"time"
+)
+
+const DefaultSweepInterval = time.Minute
+
+func (d *DB) SetWithTTL(key, value string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", fmt.Errorf("Invalid ttl of %s: %s", key, ttl)
+	}
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err = d.purge(key)
+	if err != nil {
+		return "", err
+	}
+	hash, err := d.addWithMetadata(value, metadata{expires: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	return d.setHash(key, hash, true)
+}
+
+func (d *DB) Expires(key string) (time.Time, error) {
+	d.mu.Lock()
+	root := d.latest()
+	d.mu.Unlock()
+	_, plain, err := d.read(root, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	m, _, err := unwrap(plain)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if expired(m.expires) {
+		return time.Time{}, &NotFoundError{Key: key, Root: root}
+	}
+	if m.expires == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(m.expires, 0), nil
+}
+
+func (d *DB) Sweep() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	links, err := d.links(d.latest())
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, link := range links {
+		if reserved(link.Name) || !d.expiredHash(link.Hash) {
+			continue
+		}
+		_, err := d.apply(Operation{Type: "del", Key: unescapeKey(link.Name)}, true)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	d.forget("")
+	return n, nil
+}
+
+func (d *DB) StartSweeper(interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sweeper != nil {
+		return
+	}
+	stop := make(chan struct{})
+	d.sweeper = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Sweep()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (d *DB) StopSweeper() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sweeper == nil {
+		return
+	}
+	close(d.sweeper)
+	d.sweeper = nil
+}
+
+func (d *DB) purge(except string) error {
+	if !d.anyExpired() {
+		return nil
+	}
+	links, err := d.links(d.latest())
+	if err != nil {
+		return err
+	}
+	keep := ""
+	for _, link := range links {
+		key := unescapeKey(link.Name)
+		if key == except {
+			keep = link.Hash
+		}
+		if key == except || reserved(link.Name) || !d.knownExpired(link.Hash) {
+			continue
+		}
+		_, err := d.apply(Operation{Type: "del", Key: key}, true)
+		if err != nil {
+			return err
+		}
+	}
+	d.forget(keep)
+	return nil
+}
+
+func (d *DB) forget(keep string) {
+	d.emu.Lock()
+	defer d.emu.Unlock()
+	for hash, expires := range d.expiries {
+		if hash != keep && expired(expires) {
+			delete(d.expiries, hash)
+		}
+	}
+}
+
+func (d *DB) remember(hash string, expires int64) {
+	d.emu.Lock()
+	defer d.emu.Unlock()
+	if d.expiries == nil {
+		d.expiries = map[string]int64{}
+	}
+	d.expiries[hash] = expires
+}
+
+func (d *DB) knownExpired(hash string) bool {
+	d.emu.Lock()
+	defer d.emu.Unlock()
+	expires, ok := d.expiries[hash]
+	return ok && expired(expires)
+}
+
+func (d *DB) anyExpired() bool {
+	d.emu.Lock()
+	defer d.emu.Unlock()
+	for _, expires := range d.expiries {
+		if expired(expires) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DB) expiredHash(hash string) bool {
+	d.emu.Lock()
+	expires, ok := d.expiries[hash]
+	d.emu.Unlock()
+	if ok {
+		return expired(expires)
+	}
+	plain, err := d.plain(hash)
+	if err != nil {
+		return false
+	}
+	m, _, err := unwrap(plain)
+	if err != nil {
+		return false
+	}
+	d.remember(hash, m.expires)
+	return expired(m.expires)
+}
+
+func expired(expires int64) bool {
+	return expires != 0 && time.Now().Unix() >= expires
+}
@@ -0,0 +1,84 @@
+package kaleidoscope
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDBExpiredValues(t *testing.T) {
+	keystore := testKeystore()
+	live, _ := keystore.EncryptString(wrap("live", metadata{expires: time.Now().Add(time.Hour).Unix()}))
+	dead, _ := keystore.EncryptString(wrap("dead", metadata{expires: time.Now().Add(-time.Hour).Unix()}))
+
+	var removed []string
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arg := r.URL.Query().Get("arg")
+		switch r.URL.Path {
+		case "/api/v0/object/links":
+			fmt.Fprintln(w, `{"Hash":"QmRoot","Links":[{"Name":"a","Hash":"QmLive"},{"Name":"b","Hash":"QmDead"}]}`)
+		case "/api/v0/cat":
+			if strings.Contains(arg, "QmDead") || strings.Contains(arg, "/b/") {
+				w.Write(dead)
+			} else {
+				w.Write(live)
+			}
+		case "/api/v0/object/patch/rm-link":
+			removed = append(removed, r.URL.Query()["arg"][1])
+			fmt.Fprintln(w, `{"Hash":"QmRootAfter"}`)
+		}
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = keystore
+
+	_, value, err := db.Get("a")
+	if err != nil || string(value) != "live" {
+		t.Errorf("Get should return unexpired value, but %s %v", value, err)
+	}
+	_, _, err = db.Get("b")
+	if err == nil {
+		t.Errorf("Get should treat expired value as absent")
+	}
+
+	keys, err := db.Keys()
+	if err != nil {
+		t.Errorf("Keys should not return error, but %s", err)
+	}
+	if strings.Join(keys, ",") != "a" {
+		t.Errorf("Keys should exclude expired keys, but %v", keys)
+	}
+
+	n, err := db.Sweep()
+	if err != nil {
+		t.Errorf("Sweep should not return error, but %s", err)
+	}
+	if n != 1 || strings.Join(removed, ",") != "b" {
+		t.Errorf("Sweep should remove expired keys, but %d %v", n, removed)
+	}
+}
+
+func TestDBSetWithTTLInvalid(t *testing.T) {
+	requests := 0
+	ipfs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ipfs.Close()
+
+	db := testDB(ipfs.URL, "QmRoot")
+	db.keystore = testKeystore()
+
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		_, err := db.SetWithTTL("a", "1", ttl)
+		if err == nil {
+			t.Errorf("SetWithTTL should reject ttl %s", ttl)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("SetWithTTL should not write a value with an invalid ttl, but %d requests", requests)
+	}
+}
@@ -0,0 +1,58 @@
+package kaleidoscope
+
+const watchBuffer = 64
+
+type Event struct {
+	Type       string
+	Database   string
+	Collection string
+	Key        string
+	Hash       string
+	Head       string
+}
+
+func (d *DB) Watch() (<-chan Event, func()) {
+	ch := make(chan Event, watchBuffer)
+	d.wmu.Lock()
+	if d.watchers == nil {
+		d.watchers = map[chan Event]struct{}{}
+	}
+	d.watchers[ch] = struct{}{}
+	d.wmu.Unlock()
+
+	cancel := func() {
+		d.wmu.Lock()
+		defer d.wmu.Unlock()
+		if _, ok := d.watchers[ch]; ok {
+			delete(d.watchers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+func (d *DB) notify(ope Operation) {
+	if ope.Type == "batch" {
+		for _, e := range ope.Entries {
+			d.notify(Operation{Type: "set", Collection: ope.Collection, Key: e.Key, Hash: e.Hash})
+		}
+		return
+	}
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	ev := Event{
+		Type:       ope.Type,
+		Database:   d.name,
+		Collection: ope.Collection,
+		Key:        ope.Key,
+		Hash:       ope.Hash,
+		Head:       d.head,
+	}
+	for ch := range d.watchers {
+		select {
+		case ch <- ev:
+		default:
+			// Drop events for slow watchers rather than blocking writers.
+		}
+	}
+}